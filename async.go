@@ -0,0 +1,264 @@
+package klog
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOutputClosed is returned when writing to an output that was already closed.
+var ErrOutputClosed = errors.New("klog: output is closed")
+
+// OverflowPolicy describes what the AsyncOutput should do
+// when a new entry arrives and its queue is full.
+type OverflowPolicy int
+
+const (
+	// Block makes the caller wait until there is room on the queue.
+	Block OverflowPolicy = iota
+
+	// DropNewest discards the entry that has just arrived.
+	DropNewest
+
+	// DropOldest discards the oldest entry on the queue
+	// to make room for the one that has just arrived.
+	DropOldest
+
+	// DropBelowLevel discards the entry that has just arrived
+	// if its level is below AsyncConfig.MinLevel, otherwise
+	// it blocks just like the Block policy.
+	DropBelowLevel
+)
+
+// AsyncConfig contains the optional configurations for the AsyncOutput.
+type AsyncConfig struct {
+	// QueueSize is the maximum number of entries waiting
+	// to be written, defaults to 1024.
+	QueueSize int
+
+	// OverflowPolicy defaults to Block.
+	OverflowPolicy OverflowPolicy
+
+	// MinLevel is only used by the DropBelowLevel policy
+	// and defaults to "ERROR".
+	MinLevel string
+}
+
+// AsyncStats contains the counters of an AsyncOutput.
+type AsyncStats struct {
+	Written uint64
+	Dropped uint64
+	Errors  uint64
+}
+
+var _ Output = &AsyncOutput{}
+
+// AsyncOutput is an Output that writes the entries to another Output
+// in a separate goroutine so slow outputs don't stall the callers.
+//
+// The entries are kept on a bounded ring buffer and what happens when
+// it gets full is decided by the configured OverflowPolicy.
+type AsyncOutput struct {
+	out Output
+
+	policy      OverflowPolicy
+	minPriority uint
+
+	mutex    sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	idle     *sync.Cond
+
+	queue   []asyncEntry
+	head    int
+	size    int
+	writing bool
+	closed  bool
+	done    chan struct{}
+
+	stats AsyncStats
+}
+
+type asyncEntry struct {
+	time time.Time
+	data LogData
+}
+
+// NewAsyncOutput starts a goroutine that writes to the received
+// Output all entries written to the returned AsyncOutput.
+//
+// Close must be called before the program exits so that no
+// entries are lost.
+func NewAsyncOutput(out Output, config AsyncConfig) *AsyncOutput {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+	if config.MinLevel == "" {
+		config.MinLevel = "ERROR"
+	}
+
+	a := &AsyncOutput{
+		out:         out,
+		policy:      config.OverflowPolicy,
+		minPriority: LevelPriority(config.MinLevel),
+		queue:       make([]asyncEntry, config.QueueSize),
+		done:        make(chan struct{}),
+	}
+	a.notEmpty = sync.NewCond(&a.mutex)
+	a.notFull = sync.NewCond(&a.mutex)
+	a.idle = sync.NewCond(&a.mutex)
+
+	go a.run()
+
+	return a
+}
+
+// WriteLog implements the Output interface
+func (a *AsyncOutput) WriteLog(t time.Time, data *LogData) error {
+	entry := asyncEntry{
+		time: t,
+		data: copyLogData(data),
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return ErrOutputClosed
+	}
+
+	for a.size == len(a.queue) {
+		switch a.policy {
+		case DropNewest:
+			a.stats.Dropped++
+			return nil
+		case DropOldest:
+			a.head = (a.head + 1) % len(a.queue)
+			a.size--
+			a.stats.Dropped++
+		case DropBelowLevel:
+			if LevelPriority(data.Level) < a.minPriority {
+				a.stats.Dropped++
+				return nil
+			}
+			a.notFull.Wait()
+		default:
+			a.notFull.Wait()
+		}
+
+		if a.closed {
+			return ErrOutputClosed
+		}
+	}
+
+	a.queue[(a.head+a.size)%len(a.queue)] = entry
+	a.size++
+	a.notEmpty.Signal()
+
+	return nil
+}
+
+// Stats returns a snapshot of the counters of this output.
+func (a *AsyncOutput) Stats() AsyncStats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.stats
+}
+
+// Flush waits until all the entries on the queue are written
+// and then flushes the underlying Output if it is a Flusher.
+func (a *AsyncOutput) Flush() error {
+	a.mutex.Lock()
+	for a.size > 0 || a.writing {
+		a.idle.Wait()
+	}
+	a.mutex.Unlock()
+
+	if f, ok := a.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close stops accepting new entries, drains the queue and then
+// closes the underlying Output if it has a Close method.
+//
+// Calling Close more than once is safe.
+func (a *AsyncOutput) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.notEmpty.Broadcast()
+	a.notFull.Broadcast()
+	a.mutex.Unlock()
+
+	<-a.done
+
+	if f, ok := a.out.(Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if c, ok := a.out.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (a *AsyncOutput) run() {
+	defer close(a.done)
+
+	for {
+		a.mutex.Lock()
+		for a.size == 0 && !a.closed {
+			a.idle.Broadcast()
+			a.notEmpty.Wait()
+		}
+		if a.size == 0 {
+			a.idle.Broadcast()
+			a.mutex.Unlock()
+			return
+		}
+
+		entry := a.queue[a.head]
+		a.queue[a.head] = asyncEntry{}
+		a.head = (a.head + 1) % len(a.queue)
+		a.size--
+		a.writing = true
+		a.notFull.Signal()
+		a.mutex.Unlock()
+
+		err := a.out.WriteLog(entry.time, &entry.data)
+
+		a.mutex.Lock()
+		a.writing = false
+		if err != nil {
+			a.stats.Errors++
+		} else {
+			a.stats.Written++
+		}
+		a.mutex.Unlock()
+
+		if err != nil {
+			reportOutputError(err, &entry.data)
+		}
+	}
+}
+
+// copyLogData makes a shallow copy of the data so that
+// later changes to its Body are not visible on the copy.
+func copyLogData(data *LogData) LogData {
+	body := make(Body, len(data.Body))
+	for k, v := range data.Body {
+		body[k] = v
+	}
+
+	return LogData{
+		Level: data.Level,
+		Title: data.Title,
+		Body:  body,
+	}
+}
@@ -0,0 +1,193 @@
+package klog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncOutput(t *testing.T) {
+	t.Run("should write all entries in order after a flush", func(t *testing.T) {
+		var titles []string
+		async := NewAsyncOutput(OutputFunc(func(_ time.Time, data *LogData) error {
+			titles = append(titles, data.Title)
+			return nil
+		}), AsyncConfig{})
+		defer func() { _ = async.Close() }()
+
+		for i := 0; i < 100; i++ {
+			err := async.WriteLog(time.Now(), &LogData{Level: "INFO", Title: fmt.Sprint(i)})
+			assert.Equal(t, nil, err)
+		}
+
+		err := async.Flush()
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 100, len(titles))
+		assert.Equal(t, "0", titles[0])
+		assert.Equal(t, "99", titles[99])
+		assert.Equal(t, AsyncStats{Written: 100}, async.Stats())
+	})
+
+	t.Run("should keep the time the entry was logged", func(t *testing.T) {
+		var times []time.Time
+		async := NewAsyncOutput(OutputFunc(func(t time.Time, _ *LogData) error {
+			times = append(times, t)
+			return nil
+		}), AsyncConfig{})
+
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		_ = async.WriteLog(now, &LogData{Level: "INFO", Title: "fake-title"})
+
+		err := async.Close()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []time.Time{now}, times)
+	})
+
+	t.Run("should not be affected by changes made to the body after WriteLog returns", func(t *testing.T) {
+		var bodies []Body
+		async := NewAsyncOutput(OutputFunc(func(_ time.Time, data *LogData) error {
+			bodies = append(bodies, data.Body)
+			return nil
+		}), AsyncConfig{})
+
+		data := LogData{Level: "INFO", Title: "fake-title", Body: Body{"key": "value"}}
+		_ = async.WriteLog(time.Now(), &data)
+		data.Body["key"] = "changed"
+
+		_ = async.Close()
+		assert.Equal(t, []Body{{"key": "value"}}, bodies)
+	})
+
+	tests := []struct {
+		desc           string
+		config         AsyncConfig
+		levels         []string
+		expectedTitles []string
+		expectedStats  AsyncStats
+	}{
+		{
+			desc:           "should drop the newest entries when the policy is DropNewest",
+			config:         AsyncConfig{QueueSize: 2, OverflowPolicy: DropNewest},
+			levels:         []string{"INFO", "INFO", "INFO", "INFO"},
+			expectedTitles: []string{"blocker", "0", "1"},
+			expectedStats:  AsyncStats{Written: 3, Dropped: 2},
+		},
+		{
+			desc:           "should drop the oldest entries when the policy is DropOldest",
+			config:         AsyncConfig{QueueSize: 2, OverflowPolicy: DropOldest},
+			levels:         []string{"INFO", "INFO", "INFO", "INFO"},
+			expectedTitles: []string{"blocker", "2", "3"},
+			expectedStats:  AsyncStats{Written: 3, Dropped: 2},
+		},
+		{
+			desc:           "should drop only entries below the min level when the policy is DropBelowLevel",
+			config:         AsyncConfig{QueueSize: 2, OverflowPolicy: DropBelowLevel, MinLevel: "WARN"},
+			levels:         []string{"INFO", "INFO", "DEBUG", "INFO"},
+			expectedTitles: []string{"blocker", "0", "1"},
+			expectedStats:  AsyncStats{Written: 3, Dropped: 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			unblock := make(chan struct{})
+			started := make(chan struct{})
+
+			var titles []string
+			async := NewAsyncOutput(OutputFunc(func(_ time.Time, data *LogData) error {
+				if data.Title == "blocker" {
+					close(started)
+					<-unblock
+				}
+				titles = append(titles, data.Title)
+				return nil
+			}), test.config)
+
+			_ = async.WriteLog(time.Now(), &LogData{Level: "ERROR", Title: "blocker"})
+			<-started
+
+			for i, level := range test.levels {
+				_ = async.WriteLog(time.Now(), &LogData{Level: level, Title: fmt.Sprint(i)})
+			}
+
+			close(unblock)
+			_ = async.Close()
+
+			assert.Equal(t, test.expectedTitles, titles)
+			assert.Equal(t, test.expectedStats, async.Stats())
+		})
+	}
+
+	t.Run("should block when the policy is Block and the queue is full", func(t *testing.T) {
+		unblock := make(chan struct{})
+		started := make(chan struct{})
+
+		var mutex sync.Mutex
+		var titles []string
+		async := NewAsyncOutput(OutputFunc(func(_ time.Time, data *LogData) error {
+			if data.Title == "blocker" {
+				close(started)
+				<-unblock
+			}
+			mutex.Lock()
+			titles = append(titles, data.Title)
+			mutex.Unlock()
+			return nil
+		}), AsyncConfig{QueueSize: 1})
+
+		_ = async.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "blocker"})
+		<-started
+		_ = async.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "queued"})
+
+		returned := make(chan struct{})
+		go func() {
+			_ = async.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "blocked"})
+			close(returned)
+		}()
+
+		select {
+		case <-returned:
+			t.Fatal("expected WriteLog to block while the queue is full")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(unblock)
+		<-returned
+		_ = async.Close()
+
+		assert.Equal(t, []string{"blocker", "queued", "blocked"}, titles)
+	})
+
+	t.Run("should return an error when writing after Close", func(t *testing.T) {
+		async := NewAsyncOutput(OutputFunc(func(time.Time, *LogData) error {
+			return nil
+		}), AsyncConfig{})
+		_ = async.Close()
+
+		err := async.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "fake-title"})
+		assert.Equal(t, ErrOutputClosed, err)
+	})
+
+	t.Run("should work as the output of a Client", func(t *testing.T) {
+		var output strings.Builder
+		async := NewAsyncOutput(NewJSONOutput(&output), AsyncConfig{})
+		defer func() { _ = async.Close() }()
+
+		client := New("INFO")
+		client.timeNow = func() time.Time {
+			return parseTime(t, "2024-10-09T09:00:00Z")
+		}
+		client.SetOutput(async)
+
+		client.Info(context.TODO(), "fake-title", Body{"key": "value"})
+
+		err := client.Flush()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title","key":"value"}`+"\n", output.String())
+	})
+}
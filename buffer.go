@@ -0,0 +1,143 @@
+package klog
+
+import (
+	"context"
+	"sync"
+)
+
+type bufferCtxKey struct{}
+
+type bufferState int
+
+const (
+	buffering bufferState = iota
+	failed
+	discarded
+)
+
+// requestBuffer holds the "DEBUG" and "INFO" entries of a single
+// request until it either fails or finishes successfully.
+type requestBuffer struct {
+	mutex   sync.Mutex
+	state   bufferState
+	entries []func()
+	size    int
+}
+
+// ContextWithBuffer returns a context that makes the Client hold the
+// "DEBUG" and "INFO" entries logged with it on a buffer, independent
+// of the level of the Client, instead of writing them right away.
+//
+// The buffered entries are written, in order, as soon as an "ERROR" is
+// logged with the context or MarkFailed is called, and from then on all
+// entries are written directly. DiscardBuffer discards them instead.
+//
+// Only the last size entries are kept, if size is not positive
+// it defaults to 1000.
+func ContextWithBuffer(ctx context.Context, size int) context.Context {
+	if size <= 0 {
+		size = 1000
+	}
+	return context.WithValue(ctx, bufferCtxKey{}, &requestBuffer{
+		size: size,
+	})
+}
+
+// MarkFailed writes all the entries held on the buffer of the context,
+// if any, and makes all the entries logged with it from then on,
+// including the "DEBUG" ones, to be written directly.
+func MarkFailed(ctx context.Context) {
+	buffer := bufferFromContext(ctx)
+	if buffer == nil {
+		return
+	}
+
+	buffer.fail()
+}
+
+// DiscardBuffer discards all the entries held on the buffer of the
+// context, if any, and makes all the entries logged with it from then
+// on to be handled as if the context had no buffer.
+func DiscardBuffer(ctx context.Context) {
+	buffer := bufferFromContext(ctx)
+	if buffer == nil {
+		return
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	if buffer.state == buffering {
+		buffer.entries = nil
+		buffer.state = discarded
+	}
+}
+
+func bufferFromContext(ctx context.Context) *requestBuffer {
+	if ctx == nil {
+		return nil
+	}
+	buffer, _ := ctx.Value(bufferCtxKey{}).(*requestBuffer)
+	return buffer
+}
+
+// accepts reports whether an entry with the received level should
+// be processed by a Client with the received priority.
+//
+// Only DEBUG and INFO entries skip the level of the Client,
+// all the other levels are dropped when below it.
+func (b *requestBuffer) accepts(level string, clientPriority uint) bool {
+	if LevelPriority(level) >= clientPriority {
+		return true
+	}
+	if level != "DEBUG" && level != "INFO" {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state != discarded
+}
+
+// hold keeps the write function on the buffer and returns true if the
+// entry should be buffered, otherwise it returns false so the entry is
+// written by the caller, writing the buffered entries first on errors.
+func (b *requestBuffer) hold(level string, write func()) bool {
+	b.mutex.Lock()
+	if b.state != buffering {
+		b.mutex.Unlock()
+		return false
+	}
+
+	switch level {
+	case "DEBUG", "INFO":
+		if len(b.entries) == b.size {
+			b.entries[0] = nil
+			b.entries = b.entries[1:]
+		}
+		b.entries = append(b.entries, write)
+		b.mutex.Unlock()
+		return true
+	case "ERROR":
+		b.mutex.Unlock()
+		b.fail()
+		return false
+	default:
+		b.mutex.Unlock()
+		return false
+	}
+}
+
+// fail writes the buffered entries and stops buffering new ones.
+func (b *requestBuffer) fail() {
+	b.mutex.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.state = failed
+	b.mutex.Unlock()
+
+	for _, write := range entries {
+		write()
+	}
+}
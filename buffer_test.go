@@ -0,0 +1,116 @@
+package klog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithBuffer(t *testing.T) {
+	newClient := func(level string, titles *[]string) *Client {
+		client := New(level)
+		client.OutputHandler = func(data *LogData) {
+			*titles = append(*titles, data.Title)
+		}
+		return client
+	}
+
+	t.Run("should write the buffered entries when an error is logged", func(t *testing.T) {
+		var titles []string
+		client := newClient("INFO", &titles)
+
+		ctx := ContextWithBuffer(context.TODO(), 0)
+		client.Debug(ctx, "debug")
+		client.Info(ctx, "info")
+		client.Warn(ctx, "warn")
+		assert.Equal(t, []string{"warn"}, titles)
+
+		client.Error(ctx, "error")
+		assert.Equal(t, []string{"warn", "debug", "info", "error"}, titles)
+
+		// Entries logged after the error are written directly:
+		client.Debug(ctx, "debug-after-error")
+		assert.Equal(t, []string{"warn", "debug", "info", "error", "debug-after-error"}, titles)
+	})
+
+	t.Run("should write the buffered entries when the request is marked as failed", func(t *testing.T) {
+		var titles []string
+		client := newClient("INFO", &titles)
+
+		ctx := ContextWithBuffer(context.TODO(), 0)
+		client.Debug(ctx, "debug")
+		MarkFailed(ctx)
+
+		assert.Equal(t, []string{"debug"}, titles)
+	})
+
+	t.Run("should discard the buffered entries and respect the level afterwards", func(t *testing.T) {
+		var titles []string
+		client := newClient("INFO", &titles)
+
+		ctx := ContextWithBuffer(context.TODO(), 0)
+		client.Debug(ctx, "debug")
+		client.Info(ctx, "info")
+		DiscardBuffer(ctx)
+
+		client.Debug(ctx, "debug-after-discard")
+		client.Info(ctx, "info-after-discard")
+		client.Error(ctx, "error-after-discard")
+
+		assert.Equal(t, []string{"info-after-discard", "error-after-discard"}, titles)
+	})
+
+	t.Run("should respect the level for entries above INFO", func(t *testing.T) {
+		var titles []string
+		client := newClient("ERROR", &titles)
+
+		ctx := ContextWithBuffer(context.TODO(), 0)
+		client.Info(ctx, "info")
+		client.Warn(ctx, "warn")
+		assert.Equal(t, []string(nil), titles)
+
+		client.Error(ctx, "error")
+		client.Warn(ctx, "warn-after-error")
+		assert.Equal(t, []string{"info", "error"}, titles)
+	})
+
+	t.Run("should keep only the last entries when the buffer is full", func(t *testing.T) {
+		var titles []string
+		client := newClient("INFO", &titles)
+
+		ctx := ContextWithBuffer(context.TODO(), 2)
+		for i := 0; i < 5; i++ {
+			client.Info(ctx, fmt.Sprint(i))
+		}
+		MarkFailed(ctx)
+
+		assert.Equal(t, []string{"3", "4"}, titles)
+	})
+
+	t.Run("should keep the time the buffered entries were logged", func(t *testing.T) {
+		var times []time.Time
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+
+		client := New("INFO")
+		client.timeNow = func() time.Time {
+			return now
+		}
+		client.SetOutput(OutputFunc(func(t time.Time, _ *LogData) error {
+			times = append(times, t)
+			return nil
+		}))
+
+		ctx := ContextWithBuffer(context.TODO(), 0)
+		client.Debug(ctx, "debug")
+		now = now.Add(time.Second)
+		client.Error(ctx, "error")
+
+		assert.Equal(t, []time.Time{
+			parseTime(t, "2024-10-09T09:00:00Z"),
+			parseTime(t, "2024-10-09T09:00:01Z"),
+		}, times)
+	})
+}
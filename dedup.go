@@ -0,0 +1,238 @@
+package klog
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DedupConfig contains the optional configurations for the Deduplicator.
+type DedupConfig struct {
+	// Window is how long an entry suppresses identical entries
+	// after it is written.
+	//
+	// If left as zero only consecutive identical entries are suppressed.
+	//
+	// When set, the streaks are also checked every Window on a separate
+	// goroutine, so that their summaries are written even if no other
+	// entries arrive.
+	Window time.Duration
+}
+
+var _ Output = &Deduplicator{}
+
+// Deduplicator is an Output that suppresses identical entries, i.e.
+// entries with the same level, title and Body, before writing them
+// to another Output.
+//
+// When a streak of identical entries ends the Deduplicator writes an
+// entry titled "log-entry-repeated", on the level of the repeated
+// entry, with its title, the number of suppressed entries and the
+// times of the first and last occurrences.
+type Deduplicator struct {
+	out    Output
+	config DedupConfig
+	now    func() time.Time
+
+	mutex     sync.Mutex
+	streaks   map[uint64]*dedupStreak
+	last      uint64
+	lastSweep time.Time
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type dedupStreak struct {
+	data     LogData
+	first    time.Time
+	last     time.Time
+	repeated int
+}
+
+// NewDeduplicator returns a Deduplicator that writes
+// the unique entries to the received Output.
+//
+// If a Window is configured Close must be called to stop
+// the goroutine that writes the summaries of the streaks.
+func NewDeduplicator(out Output, config DedupConfig) *Deduplicator {
+	d := &Deduplicator{
+		out:     out,
+		config:  config,
+		now:     time.Now,
+		streaks: map[uint64]*dedupStreak{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if config.Window > 0 {
+		go d.run()
+	} else {
+		close(d.done)
+	}
+
+	return d
+}
+
+// WriteLog implements the Output interface
+func (d *Deduplicator) WriteLog(t time.Time, data *LogData) error {
+	hash := hashLogData(data)
+
+	d.mutex.Lock()
+	var ended []*dedupStreak
+	if d.config.Window <= 0 {
+		if hash != d.last {
+			ended = d.endStreaks(func(*dedupStreak) bool { return true })
+		}
+	} else if t.Sub(d.lastSweep) >= d.config.Window {
+		d.lastSweep = t
+		ended = d.endStreaks(func(s *dedupStreak) bool {
+			return t.Sub(s.first) >= d.config.Window
+		})
+	}
+
+	streak, ok := d.streaks[hash]
+	if ok && (d.config.Window <= 0 || t.Sub(streak.first) < d.config.Window) {
+		streak.last = t
+		streak.repeated++
+		d.mutex.Unlock()
+
+		d.writeSummaries(ended)
+		return nil
+	}
+	if ok {
+		ended = append(ended, streak)
+	}
+
+	d.streaks[hash] = &dedupStreak{
+		data:  copyLogData(data),
+		first: t,
+		last:  t,
+	}
+	d.last = hash
+	d.mutex.Unlock()
+
+	d.writeSummaries(ended)
+	return d.out.WriteLog(t, data)
+}
+
+// Flush writes the summaries of all the current streaks
+// and then flushes the underlying Output if it is a Flusher.
+func (d *Deduplicator) Flush() error {
+	d.mutex.Lock()
+	ended := d.endStreaks(func(*dedupStreak) bool { return true })
+	d.last = 0
+	d.mutex.Unlock()
+
+	d.writeSummaries(ended)
+
+	if f, ok := d.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close stops the Deduplicator goroutine, flushes the Deduplicator
+// and then closes the underlying Output if it has a Close method.
+//
+// Calling Close more than once is safe.
+func (d *Deduplicator) Close() error {
+	closed := false
+	d.closeOnce.Do(func() {
+		closed = true
+		close(d.stop)
+	})
+	if !closed {
+		return nil
+	}
+	<-d.done
+
+	err := d.Flush()
+	if err != nil {
+		return err
+	}
+
+	if c, ok := d.out.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (d *Deduplicator) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+
+		d.mutex.Lock()
+		now := d.now()
+		ended := d.endStreaks(func(s *dedupStreak) bool {
+			return now.Sub(s.first) >= d.config.Window
+		})
+		d.mutex.Unlock()
+
+		d.writeSummaries(ended)
+	}
+}
+
+// endStreaks removes the streaks matching the filter and
+// returns them, it must be called with the mutex locked.
+func (d *Deduplicator) endStreaks(filter func(*dedupStreak) bool) []*dedupStreak {
+	var ended []*dedupStreak
+	for hash, streak := range d.streaks {
+		if filter(streak) {
+			delete(d.streaks, hash)
+			ended = append(ended, streak)
+		}
+	}
+	return ended
+}
+
+func (d *Deduplicator) writeSummaries(streaks []*dedupStreak) {
+	sort.Slice(streaks, func(i, j int) bool {
+		return streaks[i].last.Before(streaks[j].last)
+	})
+
+	for _, streak := range streaks {
+		if streak.repeated == 0 {
+			continue
+		}
+
+		summary := LogData{
+			Level: streak.data.Level,
+			Title: "log-entry-repeated",
+			Body: Body{
+				"repeated_title": streak.data.Title,
+				"repeated":       streak.repeated,
+				"first_seen":     streak.first.Format(time.RFC3339Nano),
+				"last_seen":      streak.last.Format(time.RFC3339Nano),
+			},
+		}
+		err := d.out.WriteLog(streak.last, &summary)
+		if err != nil {
+			reportOutputError(err, &summary)
+		}
+	}
+}
+
+// hashLogData hashes the level, title and Body of the entry,
+// the Body is hashed using its JSON encoding which has the
+// keys sorted, so equal bodies always produce the same hash.
+func hashLogData(data *LogData) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(data.Level))
+	h.Write([]byte{0})
+	h.Write([]byte(data.Title))
+	h.Write([]byte{0})
+	h.Write([]byte(escapeAsJSON(data.Body)))
+	return h.Sum64()
+}
@@ -0,0 +1,160 @@
+package klog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicator(t *testing.T) {
+	start := parseTime(t, "2024-10-09T09:00:00Z")
+
+	type write struct {
+		delay time.Duration
+		data  LogData
+	}
+
+	failure := LogData{Level: "ERROR", Title: "request-failed", Body: Body{"error": "timeout"}}
+	success := LogData{Level: "INFO", Title: "request-succeeded"}
+
+	tests := []struct {
+		desc            string
+		config          DedupConfig
+		writes          []write
+		expectedEntries []LogData
+	}{
+		{
+			desc: "should suppress consecutive identical entries",
+			writes: []write{
+				{0, failure},
+				{time.Second, failure},
+				{2 * time.Second, failure},
+				{3 * time.Second, success},
+			},
+			expectedEntries: []LogData{
+				failure,
+				{
+					Level: "ERROR",
+					Title: "log-entry-repeated",
+					Body: Body{
+						"repeated_title": "request-failed",
+						"repeated":       2,
+						"first_seen":     "2024-10-09T09:00:00Z",
+						"last_seen":      "2024-10-09T09:00:02Z",
+					},
+				},
+				success,
+			},
+		},
+		{
+			desc: "should not suppress entries with different bodies",
+			writes: []write{
+				{0, failure},
+				{time.Second, LogData{Level: "ERROR", Title: "request-failed", Body: Body{"error": "refused"}}},
+			},
+			expectedEntries: []LogData{
+				failure,
+				{Level: "ERROR", Title: "request-failed", Body: Body{"error": "refused"}},
+			},
+		},
+		{
+			desc:   "should suppress non consecutive entries within the window",
+			config: DedupConfig{Window: time.Minute},
+			writes: []write{
+				{0, failure},
+				{time.Second, success},
+				{2 * time.Second, failure},
+				{time.Minute, failure},
+			},
+			expectedEntries: []LogData{
+				failure,
+				success,
+				{
+					Level: "ERROR",
+					Title: "log-entry-repeated",
+					Body: Body{
+						"repeated_title": "request-failed",
+						"repeated":       1,
+						"first_seen":     "2024-10-09T09:00:00Z",
+						"last_seen":      "2024-10-09T09:00:02Z",
+					},
+				},
+				failure,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var entries []LogData
+			dedup := NewDeduplicator(OutputFunc(func(_ time.Time, data *LogData) error {
+				entries = append(entries, *data)
+				return nil
+			}), test.config)
+			defer func() { _ = dedup.Close() }()
+
+			for _, w := range test.writes {
+				data := w.data
+				err := dedup.WriteLog(start.Add(w.delay), &data)
+				assert.Equal(t, nil, err)
+			}
+
+			assert.Equal(t, test.expectedEntries, entries)
+		})
+	}
+
+	t.Run("should write the summary of the current streak on Flush", func(t *testing.T) {
+		var entries []LogData
+		dedup := NewDeduplicator(OutputFunc(func(_ time.Time, data *LogData) error {
+			entries = append(entries, *data)
+			return nil
+		}), DedupConfig{})
+
+		for i := 0; i < 3; i++ {
+			data := failure
+			_ = dedup.WriteLog(start.Add(time.Duration(i)*time.Second), &data)
+		}
+
+		err := dedup.Flush()
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, "log-entry-repeated", entries[1].Title)
+		assert.Equal(t, 2, entries[1].Body["repeated"])
+
+		// A new streak starts after the flush:
+		data := failure
+		_ = dedup.WriteLog(start.Add(time.Minute), &data)
+		assert.Equal(t, 3, len(entries))
+		assert.Equal(t, "request-failed", entries[2].Title)
+	})
+
+	t.Run("should write the summary when the window ends without new entries", func(t *testing.T) {
+		var mutex sync.Mutex
+		var entries []LogData
+		dedup := NewDeduplicator(OutputFunc(func(_ time.Time, data *LogData) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			entries = append(entries, *data)
+			return nil
+		}), DedupConfig{Window: 10 * time.Millisecond})
+		defer func() { _ = dedup.Close() }()
+
+		for i := 0; i < 3; i++ {
+			data := failure
+			_ = dedup.WriteLog(time.Now(), &data)
+		}
+
+		assert.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(entries) == 2
+		}, time.Second, time.Millisecond)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Equal(t, "log-entry-repeated", entries[1].Title)
+		assert.Equal(t, 2, entries[1].Body["repeated"])
+	})
+}
@@ -0,0 +1,527 @@
+package klog
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vingarcia/klog/internal/fields"
+	"github.com/vingarcia/klog/internal/visit"
+)
+
+// BytesEncoding describes how []byte values are written on the logs.
+type BytesEncoding int
+
+const (
+	// Base64Bytes encodes []byte values as base64 strings,
+	// just like encoding/json does, this is the default.
+	Base64Bytes BytesEncoding = iota
+
+	// HexBytes encodes []byte values as hexadecimal strings.
+	HexBytes
+
+	// UTF8Bytes writes []byte values as plain strings when they
+	// contain valid UTF-8 and falls back to base64 otherwise.
+	UTF8Bytes
+)
+
+// SetBytesEncoding configures how the []byte values
+// found on the Body are encoded, defaults to Base64Bytes.
+func (c *Client) SetBytesEncoding(encoding BytesEncoding) {
+	c.convertOptions.bytes = encoding
+}
+
+func encodeBytes(b []byte, encoding BytesEncoding) interface{} {
+	switch encoding {
+	case HexBytes:
+		return hex.EncodeToString(b)
+	case UTF8Bytes:
+		if utf8.Valid(b) {
+			return string(b)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+const (
+	// maxEncodeDepth limits how deep values are encoded,
+	// deeper values are replaced by maxDepthMarker.
+	maxEncodeDepth = 64
+
+	cycleMarker    = "<cycle>"
+	maxDepthMarker = "<max depth>"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// escapeAsJSON encodes any value as JSON without ever failing,
+// the values encoding/json would reject or loop forever on are
+// encoded as follows:
+//
+//   - NaN, +Inf and -Inf floats become the strings "NaN", "+Inf" and "-Inf"
+//   - complex numbers become strings, e.g. "(1+2i)"
+//   - channels, funcs and unsafe pointers become their type, e.g. "<chan int>"
+//   - references back to a value being encoded become "<cycle>"
+//   - values nested deeper than 64 levels become "<max depth>"
+//   - values whose MarshalJSON method fails are formatted with fmt
+//
+// Maps have their keys sorted, with non-string keys converted using
+// their MarshalText method or their JSON encoding. Errors, time.Duration
+// values and basic types implementing fmt.Stringer, like enums, are
+// encoded as strings, json.Number values as numbers and []byte values
+// as base64.
+//
+// Structs that need to be converted, e.g. because they use the `klog`
+// tags, are converted the same way convertBody does it, the other ones
+// follow the `json` tags, promoting the fields of embedded structs.
+func escapeAsJSON(obj interface{}) string {
+	var e jsonEncoder
+	e.encode(reflect.ValueOf(obj), 0)
+	return string(e.buf)
+}
+
+type jsonEncoder struct {
+	buf []byte
+
+	// visiting holds the pointers, maps and slices
+	// being encoded, for detecting cycles.
+	visiting visit.Set
+}
+
+func (e *jsonEncoder) encode(rv reflect.Value, depth int) {
+	if !rv.IsValid() {
+		e.buf = append(e.buf, "null"...)
+		return
+	}
+	if depth > maxEncodeDepth {
+		e.buf = appendString(e.buf, maxDepthMarker)
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+		e.encode(rv.Elem(), depth)
+		return
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			e.buf = append(e.buf, "null"...)
+			return
+		}
+	}
+
+	if e.encodeMethods(rv, depth) {
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		e.buf = strconv.AppendBool(e.buf, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.buf = strconv.AppendInt(e.buf, rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.buf = strconv.AppendUint(e.buf, rv.Uint(), 10)
+	case reflect.Float32:
+		e.buf = appendFloat(e.buf, rv.Float(), 32)
+	case reflect.Float64:
+		e.buf = appendFloat(e.buf, rv.Float(), 64)
+	case reflect.Complex64:
+		e.buf = appendString(e.buf, strconv.FormatComplex(rv.Complex(), 'g', -1, 64))
+	case reflect.Complex128:
+		e.buf = appendString(e.buf, strconv.FormatComplex(rv.Complex(), 'g', -1, 128))
+	case reflect.String:
+		e.buf = appendString(e.buf, rv.String())
+	case reflect.Ptr:
+		if !e.visiting.Enter(rv) {
+			e.buf = appendString(e.buf, cycleMarker)
+			return
+		}
+		e.encode(rv.Elem(), depth+1)
+		e.visiting.Leave(rv)
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			e.buf = appendString(e.buf, base64.StdEncoding.EncodeToString(rv.Bytes()))
+			return
+		}
+		if !e.visiting.Enter(rv) {
+			e.buf = appendString(e.buf, cycleMarker)
+			return
+		}
+		e.encodeArray(rv, depth)
+		e.visiting.Leave(rv)
+	case reflect.Array:
+		e.encodeArray(rv, depth)
+	case reflect.Map:
+		if !e.visiting.Enter(rv) {
+			e.buf = appendString(e.buf, cycleMarker)
+			return
+		}
+		e.encodeMap(rv, depth)
+		e.visiting.Leave(rv)
+	case reflect.Struct:
+		if needsConversion(rv.Type(), convertOptions{}) == convertAlways {
+			// For the values that didn't go through convertBody,
+			// e.g. the ones encoded directly with EncodeJSON:
+			c := converter{}
+			e.encode(reflect.ValueOf(c.structToMap(rv, 0)), depth+1)
+			return
+		}
+		e.buf = append(e.buf, '{')
+		e.encodeFields(rv, map[string]bool{}, nil, depth)
+		e.buf = append(e.buf, '}')
+	default:
+		// Channels, funcs and unsafe pointers:
+		e.buf = appendString(e.buf, "<"+rv.Type().String()+">")
+	}
+}
+
+// encodeMethods encodes the values whose types have methods
+// that describe how they should be logged, and reports whether
+// the value was encoded.
+//
+// Panics on these methods are encoded as strings.
+func (e *jsonEncoder) encodeMethods(rv reflect.Value, depth int) (encoded bool) {
+	if !rv.CanInterface() {
+		return false
+	}
+
+	t := rv.Type()
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() && !isMarshaler(t) && isMarshaler(reflect.PtrTo(t)) {
+		// Use the methods with pointer receivers when possible:
+		rv = rv.Addr()
+		t = rv.Type()
+	}
+
+	isBasicStringer := t.Implements(stringerType) && isBasicKind(t.Kind())
+	if t != durationType && !isBasicStringer && !t.Implements(logValuerType) &&
+		!t.Implements(errorType) && !t.Implements(jsonMarshalerType) && !t.Implements(textMarshalerType) {
+		return false
+	}
+
+	start := len(e.buf)
+	defer func() {
+		if r := recover(); r != nil {
+			e.buf = appendString(e.buf[:start], fmt.Sprintf("!PANIC: %v", r))
+			encoded = true
+		}
+	}()
+
+	switch v := rv.Interface().(type) {
+	case LogValuer:
+		e.encode(reflect.ValueOf(resolveLogValue(v)), depth+1)
+	case time.Duration:
+		e.buf = appendString(e.buf, v.String())
+	case json.Marshaler:
+		rawJSON, err := v.MarshalJSON()
+		var compacted bytes.Buffer
+		if err == nil {
+			err = json.Compact(&compacted, rawJSON)
+		}
+		if err != nil {
+			e.buf = appendString(e.buf, fmt.Sprintf("%+v", v))
+			return true
+		}
+		e.buf = append(e.buf, compacted.Bytes()...)
+	case encoding.TextMarshaler:
+		text, err := v.MarshalText()
+		if err != nil {
+			e.buf = appendString(e.buf, fmt.Sprintf("%+v", v))
+			return true
+		}
+		e.buf = appendString(e.buf, string(text))
+	case error:
+		e.buf = appendString(e.buf, v.Error())
+	case json.Number:
+		// Invalid numbers are rejected by json.Marshal:
+		number, err := json.Marshal(v)
+		if err != nil {
+			e.buf = appendString(e.buf, string(v))
+			return true
+		}
+		e.buf = append(e.buf, number...)
+	case fmt.Stringer:
+		e.buf = appendString(e.buf, v.String())
+	}
+	return true
+}
+
+func (e *jsonEncoder) encodeArray(rv reflect.Value, depth int) {
+	e.buf = append(e.buf, '[')
+	for i := 0; i < rv.Len(); i++ {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.encode(rv.Index(i), depth+1)
+	}
+	e.buf = append(e.buf, ']')
+}
+
+func (e *jsonEncoder) encodeMap(rv reflect.Value, depth int) {
+	type entry struct {
+		key     string
+		encoded string
+	}
+
+	entries := make([]entry, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key := e.mapKey(iter.Key(), depth)
+
+		start := len(e.buf)
+		e.buf = appendString(e.buf, key)
+		e.buf = append(e.buf, ':')
+		e.encode(iter.Value(), depth+1)
+		entries = append(entries, entry{
+			key:     key,
+			encoded: string(e.buf[start:]),
+		})
+		e.buf = e.buf[:start]
+	}
+
+	// Different keys might be converted to the same string,
+	// so the encoded entries are also compared for keeping
+	// the output deterministic:
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].encoded < entries[j].encoded
+	})
+
+	e.buf = append(e.buf, '{')
+	for i, entry := range entries {
+		if i > 0 {
+			e.buf = append(e.buf, ',')
+		}
+		e.buf = append(e.buf, entry.encoded...)
+	}
+	e.buf = append(e.buf, '}')
+}
+
+// mapKey converts a map key to a string, using the key itself for
+// strings, then its MarshalText method and finally its JSON encoding.
+func (e *jsonEncoder) mapKey(key reflect.Value, depth int) string {
+	if key.Kind() == reflect.Interface && !key.IsNil() {
+		key = key.Elem()
+	}
+
+	switch key.Kind() {
+	case reflect.String:
+		return key.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !key.Type().Implements(textMarshalerType) {
+			return strconv.FormatInt(key.Int(), 10)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !key.Type().Implements(textMarshalerType) {
+			return strconv.FormatUint(key.Uint(), 10)
+		}
+	}
+
+	keyEncoder := jsonEncoder{visiting: e.visiting}
+	keyEncoder.encode(key, depth+1)
+
+	var s string
+	if json.Unmarshal(keyEncoder.buf, &s) == nil {
+		return s
+	}
+	return string(keyEncoder.buf)
+}
+
+// encodeFields writes the fields of the struct following the `json`
+// tags, skipping the names used by the fields of the outer structs,
+// which take precedence over the promoted ones like on encoding/json.
+// If the same name is still used more than once only the first field
+// is written.
+func (e *jsonEncoder) encodeFields(rv reflect.Value, written map[string]bool, outer map[string]bool, depth int) {
+	structFields := fields.Of(rv.Type(), "json")
+
+	var reserved map[string]bool
+	for _, f := range structFields {
+		if !f.Inline {
+			continue
+		}
+		reserved = make(map[string]bool, len(outer)+len(structFields))
+		for name := range outer {
+			reserved[name] = true
+		}
+		for _, other := range structFields {
+			if !other.Inline {
+				reserved[other.Name] = true
+			}
+		}
+		break
+	}
+
+	for _, f := range structFields {
+		fv := rv.Field(f.Index)
+		if f.OmitEmpty && fv.IsZero() {
+			continue
+		}
+
+		if f.Inline {
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+			if e.encodeInline(fv, written, reserved, depth+1) {
+				continue
+			}
+		}
+
+		if written[f.Name] || outer[f.Name] {
+			continue
+		}
+		written[f.Name] = true
+
+		if len(written) > 1 {
+			e.buf = append(e.buf, ',')
+		}
+		e.buf = appendString(e.buf, f.Name)
+		e.buf = append(e.buf, ':')
+		e.encode(fv, depth+1)
+	}
+}
+
+// encodeInline writes the fields of an embedded struct together with
+// the fields of the outer struct, and reports false if the field should
+// be written as a regular field instead, which is also the case for
+// cycles and for structs nested too deep.
+func (e *jsonEncoder) encodeInline(fv reflect.Value, written map[string]bool, outer map[string]bool, depth int) bool {
+	if depth > maxEncodeDepth {
+		return false
+	}
+
+	nested := fv
+	if nested.Kind() == reflect.Ptr {
+		nested = nested.Elem()
+	}
+	if nested.Kind() != reflect.Struct || isMarshaler(nested.Type()) {
+		return false
+	}
+
+	if fv.Kind() == reflect.Ptr {
+		if !e.visiting.Enter(fv) {
+			return false
+		}
+		defer e.visiting.Leave(fv)
+	}
+
+	e.encodeFields(nested, written, outer, depth)
+	return true
+}
+
+func isBasicKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// appendFloat formats finite floats like encoding/json does.
+func appendFloat(b []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return appendString(b, "NaN")
+	case math.IsInf(f, 1):
+		return appendString(b, "+Inf")
+	case math.IsInf(f, -1):
+		return appendString(b, "-Inf")
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9:
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends the string as a JSON string without escaping
+// HTML characters, invalid UTF-8 is replaced by the U+FFFD character.
+func appendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, `\n`...)
+			case '\r':
+				b = append(b, `\r`...)
+			case '\t':
+				b = append(b, `\t`...)
+			default:
+				b = append(b, `\u00`...)
+				b = append(b, hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+
+		// U+2028 and U+2029 are valid JSON but break JavaScript parsers:
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, `\u202`...)
+			b = append(b, hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
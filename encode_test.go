@@ -0,0 +1,301 @@
+package klog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type color int
+
+func (c color) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+type point struct {
+	X, Y int
+}
+
+type node struct {
+	Name string `json:"name"`
+	Next *node  `json:"next,omitempty"`
+}
+
+type version struct {
+	major, minor int
+}
+
+func (v version) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("v%d.%d", v.major, v.minor)), nil
+}
+
+type panicStringer int
+
+func (panicStringer) String() string {
+	panic("fake-panic")
+}
+
+func TestEscapeAsJSON(t *testing.T) {
+	cyclicNode := &node{Name: "a"}
+	cyclicNode.Next = &node{Name: "b", Next: cyclicNode}
+
+	cyclicMap := map[string]interface{}{"key": "value"}
+	cyclicMap["self"] = cyclicMap
+
+	cyclicSlice := []interface{}{1, nil}
+	cyclicSlice[1] = cyclicSlice
+
+	shared := &point{X: 1}
+
+	var nilPointer *point
+
+	type Base struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	type derived struct {
+		Base
+		Name  string `json:"name"`
+		Email string `json:"email,omitempty"`
+	}
+	type tagged struct {
+		Name     string `klog:"name"`
+		Password string `klog:"password,redact"`
+	}
+
+	tests := []struct {
+		desc           string
+		value          interface{}
+		expectedOutput string
+	}{
+		{
+			desc:           "should encode NaN and infinities as strings",
+			value:          []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1.5},
+			expectedOutput: `["NaN","+Inf","-Inf",1.5]`,
+		},
+		{
+			desc:           "should format floats like encoding/json",
+			value:          []interface{}{1e21, 0.0000001, float32(0.1), 100.0},
+			expectedOutput: `[1e+21,1e-7,0.1,100]`,
+		},
+		{
+			desc:           "should encode complex numbers as strings",
+			value:          complex(1, 2),
+			expectedOutput: `"(1+2i)"`,
+		},
+		{
+			desc: "should sort maps with non-string keys",
+			value: map[interface{}]int{
+				2:                 2,
+				10:                10,
+				"a":               0,
+				point{X: 1, Y: 2}: 3,
+				1.5:               4,
+			},
+			expectedOutput: `{"1.5":4,"10":10,"2":2,"a":0,"{\"X\":1,\"Y\":2}":3}`,
+		},
+		{
+			desc:           "should use MarshalText for map keys",
+			value:          map[version]bool{{major: 1, minor: 2}: true},
+			expectedOutput: `{"v1.2":true}`,
+		},
+		{
+			desc:           "should encode []byte as base64",
+			value:          []byte("hello"),
+			expectedOutput: `"aGVsbG8="`,
+		},
+		{
+			desc:           "should use String on basic types",
+			value:          []color{0, 1},
+			expectedOutput: `["red","green"]`,
+		},
+		{
+			desc:           "should encode json.Number as numbers",
+			value:          []json.Number{"42", "1.5e10", "not-a-number"},
+			expectedOutput: `[42,1.5e10,"not-a-number"]`,
+		},
+		{
+			desc:           "should use MarshalText",
+			value:          net.IPv4(10, 0, 0, 1),
+			expectedOutput: `"10.0.0.1"`,
+		},
+		{
+			desc:           "should encode durations as strings",
+			value:          1500 * time.Millisecond,
+			expectedOutput: `"1.5s"`,
+		},
+		{
+			desc:           "should encode errors as strings",
+			value:          []error{errors.New("fake-error")},
+			expectedOutput: `["fake-error"]`,
+		},
+		{
+			desc:           "should encode nil pointers as null",
+			value:          map[string]interface{}{"ptr": nilPointer},
+			expectedOutput: `{"ptr":null}`,
+		},
+		{
+			desc:           "should encode channels and funcs as their types",
+			value:          []interface{}{make(chan int), func() {}},
+			expectedOutput: `["<chan int>","<func()>"]`,
+		},
+		{
+			desc:           "should detect cycles through pointers",
+			value:          cyclicNode,
+			expectedOutput: `{"name":"a","next":{"name":"b","next":"<cycle>"}}`,
+		},
+		{
+			desc:           "should detect cycles through maps",
+			value:          cyclicMap,
+			expectedOutput: `{"key":"value","self":"<cycle>"}`,
+		},
+		{
+			desc:           "should detect cycles through slices",
+			value:          cyclicSlice,
+			expectedOutput: `[1,"<cycle>"]`,
+		},
+		{
+			desc:           "should not report values referenced twice as cycles",
+			value:          []*point{shared, shared},
+			expectedOutput: `[{"X":1,"Y":0},{"X":1,"Y":0}]`,
+		},
+		{
+			desc:           "should promote the fields of embedded structs",
+			value:          derived{Base: Base{ID: 1, Name: "base-name"}, Name: "fake-name"},
+			expectedOutput: `{"id":1,"name":"fake-name"}`,
+		},
+		{
+			desc:           "should convert the structs using the klog tags",
+			value:          []tagged{{Name: "fake-name", Password: "fake-password"}},
+			expectedOutput: `[{"name":"fake-name","password":"[REDACTED]"}]`,
+		},
+		{
+			desc:           "should not escape HTML and should escape control characters",
+			value:          "<a&b>\n\x01\u2028\xff",
+			expectedOutput: `"<a&b>\n\u0001\u2028\ufffd"`,
+		},
+		{
+			desc:           "should recover from panics",
+			value:          panicStringer(0),
+			expectedOutput: `"!PANIC: fake-panic"`,
+		},
+		{
+			desc:           "should fall back to fmt when MarshalJSON fails",
+			value:          CannotBeMarshaled{},
+			expectedOutput: `"{}"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedOutput, escapeAsJSON(test.value))
+		})
+	}
+
+	t.Run("should limit the depth", func(t *testing.T) {
+		var value interface{} = "leaf"
+		for i := 0; i < 100; i++ {
+			value = []interface{}{value}
+		}
+
+		output := escapeAsJSON(value)
+
+		assert.Contains(t, output, `"<max depth>"`)
+		assert.NotContains(t, output, "leaf")
+	})
+}
+
+func TestSetBytesEncoding(t *testing.T) {
+	tests := []struct {
+		desc           string
+		encoding       BytesEncoding
+		value          []byte
+		expectedOutput string
+	}{
+		{
+			desc:           "should use base64 by default",
+			encoding:       Base64Bytes,
+			value:          []byte("hi"),
+			expectedOutput: `"aGk="`,
+		},
+		{
+			desc:           "should encode as hex",
+			encoding:       HexBytes,
+			value:          []byte("hi"),
+			expectedOutput: `"6869"`,
+		},
+		{
+			desc:           "should encode valid UTF-8 as plain strings",
+			encoding:       UTF8Bytes,
+			value:          []byte("hi"),
+			expectedOutput: `"hi"`,
+		},
+		{
+			desc:           "should fall back to base64 for invalid UTF-8",
+			encoding:       UTF8Bytes,
+			value:          []byte{0xff},
+			expectedOutput: `"/w=="`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			now := parseTime(t, "2024-10-09T09:00:00Z")
+
+			var output string
+			client := New("INFO")
+			client.OutputHandler = func(data *LogData) {
+				output = buildJSONString(now, data)
+			}
+			client.SetBytesEncoding(test.encoding)
+
+			client.Info(context.TODO(), "fake-title", Body{
+				"bytes":  test.value,
+				"nested": []interface{}{test.value},
+			})
+
+			assert.Equal(t, `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",`+
+				`"bytes":`+test.expectedOutput+`,"nested":[`+test.expectedOutput+`]}`, output)
+		})
+	}
+
+	t.Run("should not loop on cyclic values", func(t *testing.T) {
+		cyclicMap := map[string]interface{}{}
+		cyclicMap["a"] = cyclicMap
+		cyclicMap["b"] = cyclicMap
+
+		var output string
+		client := New("INFO")
+		client.OutputHandler = func(data *LogData) {
+			output = buildJSONString(time.Time{}, data)
+		}
+
+		client.Info(context.TODO(), "fake-title", Body{"cyclic": cyclicMap})
+
+		assert.Contains(t, output, `"cyclic":{"a":"<cycle>","b":"<cycle>"}`)
+	})
+
+	t.Run("should not loop on embedded pointers to the struct itself", func(t *testing.T) {
+		type Cyc struct {
+			*Cyc
+			Name string
+		}
+		c := &Cyc{Name: "fake-name"}
+		c.Cyc = c
+
+		var output string
+		client := New("INFO")
+		client.OutputHandler = func(data *LogData) {
+			output = buildJSONString(time.Time{}, data)
+		}
+
+		client.Info(context.TODO(), "fake-title", Body{"cyc": c})
+
+		assert.Contains(t, output, `"cyc":{"Cyc":"<cycle>","Name":"fake-name"}`)
+	})
+}
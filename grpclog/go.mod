@@ -0,0 +1,12 @@
+module github.com/vingarcia/klog/grpclog
+
+go 1.16
+
+require (
+	github.com/stretchr/testify v1.7.0
+	github.com/vingarcia/klog v0.1.0
+	google.golang.org/grpc v1.43.0
+)
+
+// Tests run against the klog version from this repository:
+replace github.com/vingarcia/klog => ../
@@ -0,0 +1,313 @@
+// Package grpclog provides gRPC interceptors that make klog aware
+// of the calls being served or made and log one entry per call,
+// mirroring what the httplog package does for HTTP.
+//
+// The request information is stored on the context using the
+// httplog package, so httplog.ParseContext must be registered
+// on the klog Client:
+//
+//	logger := klog.New("INFO", httplog.ParseContext)
+//	server := grpc.NewServer(
+//		grpc.ChainUnaryInterceptor(grpclog.UnaryServerInterceptor(grpclog.Config{Logger: logger})),
+//		grpc.ChainStreamInterceptor(grpclog.StreamServerInterceptor(grpclog.Config{Logger: logger})),
+//	)
+//
+// It lives on a separate module so that the klog module
+// doesn't depend on gRPC.
+package grpclog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/httplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Config contains the configurations for the interceptors.
+type Config struct {
+	// Logger is used for writing the call logs, it is required.
+	Logger klog.Provider
+
+	// RequestIDKey is the metadata key used for propagating
+	// the request IDs, defaults to "x-request-id".
+	RequestIDKey string
+
+	// MetadataKeys are the incoming metadata keys stored
+	// on the context, e.g. "user-agent".
+	MetadataKeys []string
+
+	// LevelFunc chooses the level of the call log entry from the
+	// status code, defaults to DefaultLevelFunc.
+	LevelFunc func(code codes.Code) string
+
+	// DisableCallLog disables the call log entries, which
+	// is useful when only the request context is desired.
+	DisableCallLog bool
+}
+
+// DefaultLevelFunc logs successful calls as "INFO", calls that
+// failed due to the client as "WARN" and all others as "ERROR".
+func DefaultLevelFunc(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return "INFO"
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.Unauthenticated, codes.FailedPrecondition,
+		codes.OutOfRange, codes.ResourceExhausted, codes.Aborted:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+func (c *Config) setDefaults() {
+	if c.Logger == nil {
+		panic("grpclog: missing Logger")
+	}
+	if c.RequestIDKey == "" {
+		c.RequestIDKey = "x-request-id"
+	}
+	if c.LevelFunc == nil {
+		c.LevelFunc = DefaultLevelFunc
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor that adds the call
+// information to the context and logs one entry per call.
+func UnaryServerInterceptor(config Config) grpc.UnaryServerInterceptor {
+	config.setDefaults()
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		start := time.Now()
+		ctx = serverContext(ctx, config, info.FullMethod)
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = logPanic(ctx, config, recovered, start)
+				return
+			}
+			logCall(ctx, config, "grpc-call", err, start)
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor that adds the call
+// information to the context and logs one entry per stream.
+func StreamServerInterceptor(config Config) grpc.StreamServerInterceptor {
+	config.setDefaults()
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx := serverContext(ss.Context(), config, info.FullMethod)
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = logPanic(ctx, config, recovered, start)
+				return
+			}
+			logCall(ctx, config, "grpc-call", err, start)
+		}()
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor that propagates
+// the request ID and logs one entry per call.
+func UnaryClientInterceptor(config Config) grpc.UnaryClientInterceptor {
+	config.setDefaults()
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		ctx = clientContext(ctx, config, method, cc)
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		logCall(ctx, config, "grpc-client-call", err, start)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that propagates the
+// request ID and logs one entry per stream once it finishes.
+//
+// As required by gRPC, callers must either cancel the context of the
+// stream or call RecvMsg until it returns an error, otherwise the
+// stream is never finished and the call is not logged.
+func StreamClientInterceptor(config Config) grpc.StreamClientInterceptor {
+	config.setDefaults()
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		ctx = clientContext(ctx, config, method, cc)
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			logCall(ctx, config, "grpc-client-call", err, start)
+			return nil, err
+		}
+
+		return newClientStream(ctx, cs, desc, func(err error) {
+			logCall(ctx, config, "grpc-client-call", err, start)
+		}), nil
+	}
+}
+
+func serverContext(ctx context.Context, config Config, method string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	requestID := firstValue(md, config.RequestIDKey)
+	if requestID == "" || len(requestID) > 128 {
+		requestID = httplog.NewRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(config.RequestIDKey, requestID))
+
+	values := klog.Body{
+		"request_id":  requestID,
+		"grpc_method": method,
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		values["peer"] = p.Addr.String()
+	}
+	for _, key := range config.MetadataKeys {
+		if v := firstValue(md, key); v != "" {
+			values[strings.ReplaceAll(strings.ToLower(key), "-", "_")] = v
+		}
+	}
+
+	return httplog.ContextWithValues(ctx, values)
+}
+
+func clientContext(ctx context.Context, config Config, method string, cc *grpc.ClientConn) context.Context {
+	if requestID := httplog.RequestID(ctx); requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, config.RequestIDKey, requestID)
+	}
+
+	return httplog.ContextWithValues(ctx, klog.Body{
+		"grpc_method": method,
+		"grpc_target": cc.Target(),
+	})
+}
+
+func logCall(ctx context.Context, config Config, title string, err error, start time.Time) {
+	if config.DisableCallLog {
+		return
+	}
+
+	st := status.Convert(err)
+	body := klog.Body{
+		"grpc_code":   st.Code().String(),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		body["error"] = st.Message()
+
+		var details []string
+		for _, d := range st.Details() {
+			details = append(details, fmt.Sprint(d))
+		}
+		if len(details) > 0 {
+			body["error_details"] = details
+		}
+	}
+
+	httplog.Log(config.Logger, ctx, config.LevelFunc(st.Code()), title, body)
+}
+
+func logPanic(ctx context.Context, config Config, recovered interface{}, start time.Time) error {
+	config.Logger.Error(ctx, "grpc-call-panic", klog.Body{
+		"grpc_code":   codes.Internal.String(),
+		"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+		"panic":       fmt.Sprint(recovered),
+		"stack":       string(debug.Stack()),
+	})
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// clientStream calls finish once when the stream ends, i.e. when
+// RecvMsg returns an error or io.EOF, when the single response of a
+// stream without server streaming is received, or when the context
+// of the stream is done, which covers the streams the caller stopped
+// reading from.
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+
+	once   sync.Once
+	done   chan struct{}
+	finish func(err error)
+}
+
+func newClientStream(ctx context.Context, cs grpc.ClientStream, desc *grpc.StreamDesc, finish func(err error)) *clientStream {
+	s := &clientStream{
+		ClientStream: cs,
+		desc:         desc,
+		done:         make(chan struct{}),
+		finish:       finish,
+	}
+
+	// The context of the stream is canceled by gRPC once the stream
+	// finishes, so this goroutine never outlives the stream even
+	// when the received context is never canceled:
+	go func() {
+		select {
+		case <-cs.Context().Done():
+			if ctx.Err() != nil {
+				s.end(status.FromContextError(ctx.Err()).Err())
+			}
+		case <-s.done:
+		}
+	}()
+
+	return s
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil && s.desc.ServerStreams {
+		return nil
+	}
+
+	s.end(err)
+	return err
+}
+
+func (s *clientStream) end(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		if err == io.EOF {
+			err = nil
+		}
+		s.finish(err)
+	})
+}
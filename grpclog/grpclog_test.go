@@ -0,0 +1,311 @@
+package grpclog
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/httplog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type logEntry struct {
+	level string
+	title string
+	body  klog.Body
+	ctx   klog.Body
+}
+
+type mockLogger struct {
+	mutex   sync.Mutex
+	entries []logEntry
+}
+
+func (m *mockLogger) provider() klog.Mock {
+	record := func(level string) func(ctx context.Context, title string, body klog.Body) {
+		return func(ctx context.Context, title string, body klog.Body) {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			m.entries = append(m.entries, logEntry{
+				level: level,
+				title: title,
+				body:  body,
+				ctx:   httplog.ParseContext(ctx),
+			})
+		}
+	}
+
+	return klog.Mock{
+		DebugFn: record("DEBUG"),
+		InfoFn:  record("INFO"),
+		WarnFn:  record("WARN"),
+		ErrorFn: record("ERROR"),
+	}
+}
+
+func (m *mockLogger) byTitle(title string) []logEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var entries []logEntry
+	for _, e := range m.entries {
+		if e.title == title {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// fakeHealthServer implements the health service so the
+// tests don't need generated code of their own.
+type fakeHealthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	checkFn func(ctx context.Context) error
+}
+
+func (s *fakeHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if err := s.checkFn(ctx); err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *fakeHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if err := s.checkFn(stream.Context()); err != nil {
+		return err
+	}
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+// uploadServiceDesc describes a client streaming service that
+// answers with a single response once the client closes the stream.
+var uploadServiceDesc = grpc.ServiceDesc{
+	ServiceName: "klog.test.Upload",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Upload",
+		ClientStreams: true,
+		Handler: func(_ interface{}, stream grpc.ServerStream) error {
+			for {
+				err := stream.RecvMsg(&healthpb.HealthCheckRequest{})
+				if err == io.EOF {
+					return stream.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+				}
+				if err != nil {
+					return err
+				}
+			}
+		},
+	}},
+}
+
+func startServer(t *testing.T, logger *mockLogger, checkFn func(ctx context.Context) error) healthpb.HealthClient {
+	return healthpb.NewHealthClient(startServerConn(t, logger, checkFn))
+}
+
+func startServerConn(t *testing.T, logger *mockLogger, checkFn func(ctx context.Context) error) *grpc.ClientConn {
+	config := Config{
+		Logger:       logger.provider(),
+		MetadataKeys: []string{"x-tenant"},
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(config)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(config)),
+	)
+	healthpb.RegisterHealthServer(server, &fakeHealthServer{checkFn: checkFn})
+	server.RegisterService(&uploadServiceDesc, nil)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(config)),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor(config)),
+	)
+	if err != nil {
+		t.Fatalf("unable to dial server: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func TestInterceptors(t *testing.T) {
+	t.Run("should propagate the request ID and log unary calls", func(t *testing.T) {
+		logger := &mockLogger{}
+		var serverCtx klog.Body
+		client := startServer(t, logger, func(ctx context.Context) error {
+			serverCtx = httplog.ParseContext(ctx)
+			return nil
+		})
+
+		ctx := httplog.ContextWithValues(context.TODO(), klog.Body{"request_id": "fake-request-id"})
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant", "fake-tenant")
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, "fake-request-id", serverCtx["request_id"])
+		assert.Equal(t, "/grpc.health.v1.Health/Check", serverCtx["grpc_method"])
+		assert.Equal(t, "fake-tenant", serverCtx["x_tenant"])
+		_, hasPeer := serverCtx["peer"]
+		assert.True(t, hasPeer)
+
+		serverEntries := logger.byTitle("grpc-call")
+		assert.Equal(t, 1, len(serverEntries))
+		assert.Equal(t, "INFO", serverEntries[0].level)
+		assert.Equal(t, "OK", serverEntries[0].body["grpc_code"])
+		assert.Equal(t, "fake-request-id", serverEntries[0].ctx["request_id"])
+
+		clientEntries := logger.byTitle("grpc-client-call")
+		assert.Equal(t, 1, len(clientEntries))
+		assert.Equal(t, "INFO", clientEntries[0].level)
+		assert.Equal(t, "passthrough:///bufnet", clientEntries[0].ctx["grpc_target"])
+	})
+
+	levelTests := []struct {
+		desc          string
+		err           error
+		expectedLevel string
+		expectedCode  string
+	}{
+		{
+			desc:          "should log client errors as WARN",
+			err:           status.Error(codes.NotFound, "fake-not-found"),
+			expectedLevel: "WARN",
+			expectedCode:  "NotFound",
+		},
+		{
+			desc:          "should log server errors as ERROR",
+			err:           status.Error(codes.Internal, "fake-internal"),
+			expectedLevel: "ERROR",
+			expectedCode:  "Internal",
+		},
+	}
+	for _, test := range levelTests {
+		t.Run(test.desc, func(t *testing.T) {
+			logger := &mockLogger{}
+			client := startServer(t, logger, func(context.Context) error {
+				return test.err
+			})
+
+			_, err := client.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+			assert.Equal(t, test.err.Error(), status.Convert(err).Err().Error())
+
+			for _, title := range []string{"grpc-call", "grpc-client-call"} {
+				entries := logger.byTitle(title)
+				assert.Equal(t, 1, len(entries))
+				assert.Equal(t, test.expectedLevel, entries[0].level)
+				assert.Equal(t, test.expectedCode, entries[0].body["grpc_code"])
+				assert.Equal(t, status.Convert(test.err).Message(), entries[0].body["error"])
+			}
+		})
+	}
+
+	t.Run("should log streams once they finish", func(t *testing.T) {
+		logger := &mockLogger{}
+		client := startServer(t, logger, func(context.Context) error {
+			return nil
+		})
+
+		stream, err := client.Watch(context.TODO(), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, nil, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(logger.byTitle("grpc-client-call")))
+
+		_, err = stream.Recv()
+		assert.NotEqual(t, nil, err)
+
+		serverEntries := logger.byTitle("grpc-call")
+		assert.Equal(t, 1, len(serverEntries))
+		assert.Equal(t, "/grpc.health.v1.Health/Watch", serverEntries[0].ctx["grpc_method"])
+
+		clientEntries := logger.byTitle("grpc-client-call")
+		assert.Equal(t, 1, len(clientEntries))
+		assert.Equal(t, "OK", clientEntries[0].body["grpc_code"])
+	})
+
+	t.Run("should log client streams once the response is received", func(t *testing.T) {
+		logger := &mockLogger{}
+		conn := startServerConn(t, logger, func(context.Context) error {
+			return nil
+		})
+
+		stream, err := conn.NewStream(context.TODO(), &uploadServiceDesc.Streams[0], "/klog.test.Upload/Upload")
+		assert.Equal(t, nil, err)
+
+		for i := 0; i < 3; i++ {
+			err = stream.SendMsg(&healthpb.HealthCheckRequest{})
+			assert.Equal(t, nil, err)
+		}
+		err = stream.CloseSend()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(logger.byTitle("grpc-client-call")))
+
+		var resp healthpb.HealthCheckResponse
+		err = stream.RecvMsg(&resp)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+		clientEntries := logger.byTitle("grpc-client-call")
+		assert.Equal(t, 1, len(clientEntries))
+		assert.Equal(t, "OK", clientEntries[0].body["grpc_code"])
+		assert.Equal(t, "/klog.test.Upload/Upload", clientEntries[0].ctx["grpc_method"])
+	})
+
+	t.Run("should log streams the caller stopped reading once canceled", func(t *testing.T) {
+		logger := &mockLogger{}
+		client := startServer(t, logger, func(context.Context) error {
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, nil, err)
+
+		_, err = stream.Recv()
+		assert.Equal(t, nil, err)
+		cancel()
+
+		assert.Eventually(t, func() bool {
+			return len(logger.byTitle("grpc-client-call")) == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, "Canceled", logger.byTitle("grpc-client-call")[0].body["grpc_code"])
+	})
+
+	t.Run("should recover from panics", func(t *testing.T) {
+		logger := &mockLogger{}
+		client := startServer(t, logger, func(context.Context) error {
+			panic("fake-panic")
+		})
+
+		_, err := client.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Internal, status.Code(err))
+
+		entries := logger.byTitle("grpc-call-panic")
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "fake-panic", entries[0].body["panic"])
+	})
+}
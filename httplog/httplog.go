@@ -0,0 +1,233 @@
+// Package httplog provides a net/http middleware that makes klog
+// aware of the requests being served and emits access logs.
+//
+// The middleware assigns or propagates a request ID and stores it,
+// along with the method, path and remote IP of the request, on the
+// context so that they show up on every log written with it when
+// ParseContext is registered on the klog Client:
+//
+//	logger := klog.New("INFO", httplog.ParseContext)
+//	handler = httplog.Middleware(httplog.Config{Logger: logger})(handler)
+//
+// For outgoing requests the Transport logs one entry per request
+// and forwards the request ID stored on the request context.
+package httplog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/klog"
+)
+
+// Config contains the configurations for the middleware.
+type Config struct {
+	// Logger is used for writing the access logs, it is required.
+	Logger klog.Provider
+
+	// RequestIDHeader is read for propagating request IDs and is
+	// also set on the response, defaults to "X-Request-ID".
+	RequestIDHeader string
+
+	// TrustProxyHeaders makes the remote IP be read from the
+	// X-Forwarded-For and X-Real-IP headers when present.
+	//
+	// Only enable it when running behind a trusted proxy.
+	TrustProxyHeaders bool
+
+	// LevelFunc chooses the level of the access log entry from the
+	// response status, defaults to DefaultLevelFunc.
+	LevelFunc func(status int) string
+
+	// DisableAccessLog disables the access log entries, which
+	// is useful when only the request context is desired.
+	DisableAccessLog bool
+
+	// BufferSize, when positive, makes the "DEBUG" and "INFO" entries
+	// logged with the request context be held on a buffer of this size,
+	// see klog.ContextWithBuffer.
+	//
+	// The buffered entries are only written if the request logs an
+	// "ERROR", panics or responds with a 5xx status, and discarded
+	// otherwise.
+	BufferSize int
+}
+
+// DefaultLevelFunc logs 5xx responses as "ERROR",
+// 4xx responses as "WARN" and all others as "INFO".
+func DefaultLevelFunc(status int) string {
+	switch {
+	case status >= 500:
+		return "ERROR"
+	case status >= 400:
+		return "WARN"
+	default:
+		return "INFO"
+	}
+}
+
+type ctxKey struct{}
+
+// Middleware returns a net/http middleware that adds the request
+// information to the context and logs one entry per request.
+func Middleware(config Config) func(http.Handler) http.Handler {
+	if config.Logger == nil {
+		panic("httplog: missing Logger")
+	}
+	if config.RequestIDHeader == "" {
+		config.RequestIDHeader = "X-Request-ID"
+	}
+	if config.LevelFunc == nil {
+		config.LevelFunc = DefaultLevelFunc
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := readRequestID(r, config.RequestIDHeader)
+			w.Header().Set(config.RequestIDHeader, requestID)
+
+			ctx := ContextWithValues(r.Context(), klog.Body{
+				"request_id": requestID,
+				"method":     r.Method,
+				"path":       r.URL.Path,
+				"remote_ip":  remoteIP(r, config.TrustProxyHeaders),
+			})
+			if config.BufferSize > 0 {
+				ctx = klog.ContextWithBuffer(ctx, config.BufferSize)
+			}
+			r = r.WithContext(ctx)
+
+			rw := &responseWriter{ResponseWriter: w}
+
+			defer func() {
+				recovered := recover()
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				body := klog.Body{
+					"status":      rw.statusCode(),
+					"bytes":       rw.bytes,
+					"duration_ms": durationMs(start),
+					"user_agent":  r.UserAgent(),
+				}
+
+				if recovered != nil {
+					if !rw.wroteHeader {
+						rw.WriteHeader(http.StatusInternalServerError)
+					}
+					body["status"] = http.StatusInternalServerError
+					body["panic"] = fmt.Sprint(recovered)
+					body["stack"] = string(debug.Stack())
+
+					klog.MarkFailed(ctx)
+					config.Logger.Error(ctx, "http-request-panic", body)
+					return
+				}
+
+				if rw.statusCode() >= 500 {
+					klog.MarkFailed(ctx)
+				} else {
+					klog.DiscardBuffer(ctx)
+				}
+
+				if config.DisableAccessLog {
+					return
+				}
+
+				Log(config.Logger, ctx, config.LevelFunc(rw.statusCode()), "http-request", body)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// ParseContext is a klog.ContextParser that reads
+// the values stored on the context by this package.
+func ParseContext(ctx context.Context) klog.Body {
+	body, _ := ctx.Value(ctxKey{}).(klog.Body)
+	return body
+}
+
+// ContextWithValues returns a copy of the context with the received values
+// merged with the ones already stored on it, which are read by ParseContext.
+func ContextWithValues(ctx context.Context, values klog.Body) context.Context {
+	body := klog.Body{}
+	klog.MergeMaps(&body, ParseContext(ctx), values)
+	return context.WithValue(ctx, ctxKey{}, body)
+}
+
+// RequestID returns the request ID stored on the context,
+// or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ParseContext(ctx)["request_id"].(string)
+	return id
+}
+
+// Log logs the entry using the method of the Provider matching the level.
+func Log(logger klog.Provider, ctx context.Context, level string, title string, body klog.Body) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		logger.Debug(ctx, title, body)
+	case "WARN":
+		logger.Warn(ctx, title, body)
+	case "ERROR":
+		logger.Error(ctx, title, body)
+	default:
+		logger.Info(ctx, title, body)
+	}
+}
+
+// readRequestID reads the request ID from the configured header,
+// then from the trace ID of the W3C traceparent header and
+// finally generates a new one if none is present.
+func readRequestID(r *http.Request, header string) string {
+	if id := r.Header.Get(header); id != "" && len(id) <= 128 {
+		return id
+	}
+
+	// traceparent format: version-traceid-parentid-flags
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && parts[1] != strings.Repeat("0", 32) {
+		return parts[1]
+	}
+
+	return NewRequestID()
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+func remoteIP(r *http.Request, trustProxyHeaders bool) string {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,426 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+type logEntry struct {
+	level string
+	title string
+	body  klog.Body
+	ctx   klog.Body
+}
+
+func newMockLogger(entries *[]logEntry) klog.Mock {
+	record := func(level string) func(ctx context.Context, title string, body klog.Body) {
+		return func(ctx context.Context, title string, body klog.Body) {
+			*entries = append(*entries, logEntry{
+				level: level,
+				title: title,
+				body:  body,
+				ctx:   ParseContext(ctx),
+			})
+		}
+	}
+
+	return klog.Mock{
+		DebugFn: record("DEBUG"),
+		InfoFn:  record("INFO"),
+		WarnFn:  record("WARN"),
+		ErrorFn: record("ERROR"),
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("should store the request information on the context", func(t *testing.T) {
+		var entries []logEntry
+		var handlerCtx klog.Body
+		handler := Middleware(Config{Logger: newMockLogger(&entries)})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCtx = ParseContext(r.Context())
+			_, _ = w.Write([]byte("hello"))
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/fake/path?query=1", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Request-ID", "fake-request-id")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		expectedCtx := klog.Body{
+			"request_id": "fake-request-id",
+			"method":     "GET",
+			"path":       "/fake/path",
+			"remote_ip":  "10.0.0.1",
+		}
+		assert.Equal(t, expectedCtx, handlerCtx)
+		assert.Equal(t, "fake-request-id", resp.Header().Get("X-Request-ID"))
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "INFO", entries[0].level)
+		assert.Equal(t, "http-request", entries[0].title)
+		assert.Equal(t, expectedCtx, entries[0].ctx)
+		assert.Equal(t, 200, entries[0].body["status"])
+		assert.Equal(t, 5, entries[0].body["bytes"])
+		_, hasDuration := entries[0].body["duration_ms"]
+		assert.True(t, hasDuration)
+	})
+
+	requestIDTests := []struct {
+		desc              string
+		headers           map[string]string
+		expectedRequestID string
+	}{
+		{
+			desc:              "should use the trace ID from the traceparent header",
+			headers:           map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			expectedRequestID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			desc: "should prefer the request ID header over the traceparent header",
+			headers: map[string]string{
+				"X-Request-ID": "fake-request-id",
+				"traceparent":  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			},
+			expectedRequestID: "fake-request-id",
+		},
+	}
+	for _, test := range requestIDTests {
+		t.Run(test.desc, func(t *testing.T) {
+			var entries []logEntry
+			handler := Middleware(Config{Logger: newMockLogger(&entries)})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, test.expectedRequestID, entries[0].ctx["request_id"])
+		})
+	}
+
+	t.Run("should generate a request ID when none is received", func(t *testing.T) {
+		var requestID string
+		handler := Middleware(Config{Logger: klog.Mock{}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID = RequestID(r.Context())
+		}))
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, 32, len(requestID))
+		assert.Equal(t, requestID, resp.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("should read the remote IP from proxy headers when trusted", func(t *testing.T) {
+		var entries []logEntry
+		handler := Middleware(Config{
+			Logger:            newMockLogger(&entries),
+			TrustProxyHeaders: true,
+		})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "203.0.113.7", entries[0].ctx["remote_ip"])
+	})
+
+	levelTests := []struct {
+		desc          string
+		status        int
+		expectedLevel string
+	}{
+		{
+			desc:          "should log 2xx responses as INFO",
+			status:        http.StatusCreated,
+			expectedLevel: "INFO",
+		},
+		{
+			desc:          "should log 4xx responses as WARN",
+			status:        http.StatusNotFound,
+			expectedLevel: "WARN",
+		},
+		{
+			desc:          "should log 5xx responses as ERROR",
+			status:        http.StatusBadGateway,
+			expectedLevel: "ERROR",
+		},
+	}
+	for _, test := range levelTests {
+		t.Run(test.desc, func(t *testing.T) {
+			var entries []logEntry
+			handler := Middleware(Config{Logger: newMockLogger(&entries)})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, test.expectedLevel, entries[0].level)
+			assert.Equal(t, test.status, entries[0].body["status"])
+		})
+	}
+
+	t.Run("should recover from panics and log them", func(t *testing.T) {
+		var entries []logEntry
+		handler := Middleware(Config{Logger: newMockLogger(&entries)})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic("fake-panic")
+		}))
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "ERROR", entries[0].level)
+		assert.Equal(t, "http-request-panic", entries[0].title)
+		assert.Equal(t, "fake-panic", entries[0].body["panic"])
+		assert.Equal(t, http.StatusInternalServerError, entries[0].body["status"])
+	})
+}
+
+func TestTransport(t *testing.T) {
+	t.Run("should log the request and propagate the request ID", func(t *testing.T) {
+		var receivedRequestID string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			receivedRequestID = r.Header.Get("X-Request-ID")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		var entries []logEntry
+		client := &http.Client{
+			Transport: NewTransport(TransportConfig{Logger: newMockLogger(&entries)}),
+		}
+
+		ctx := ContextWithValues(context.Background(), klog.Body{"request_id": "fake-request-id"})
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/fake/path", nil)
+		assert.Equal(t, nil, err)
+		resp, err := client.Do(req)
+		assert.Equal(t, nil, err)
+		_ = resp.Body.Close()
+
+		assert.Equal(t, "fake-request-id", receivedRequestID)
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "WARN", entries[0].level)
+		assert.Equal(t, "http-client-request", entries[0].title)
+		assert.Equal(t, klog.Body{"request_id": "fake-request-id"}, entries[0].ctx)
+		assert.Equal(t, "GET", entries[0].body["method"])
+		assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), entries[0].body["host"])
+		assert.Equal(t, "/fake/path", entries[0].body["path"])
+		assert.Equal(t, 404, entries[0].body["status"])
+		assert.Equal(t, 0, entries[0].body["retries"])
+		_, hasDuration := entries[0].body["duration_ms"]
+		assert.True(t, hasDuration)
+	})
+
+	t.Run("should retry idempotent requests and count the retries", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var entries []logEntry
+		client := &http.Client{
+			Transport: NewTransport(TransportConfig{
+				Logger:     newMockLogger(&entries),
+				MaxRetries: 3,
+				MinBackoff: time.Millisecond,
+			}),
+		}
+
+		resp, err := client.Get(server.URL)
+		assert.Equal(t, nil, err)
+		_ = resp.Body.Close()
+
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "INFO", entries[0].level)
+		assert.Equal(t, 200, entries[0].body["status"])
+		assert.Equal(t, 2, entries[0].body["retries"])
+	})
+
+	t.Run("should only retry non-idempotent requests with an idempotency key", func(t *testing.T) {
+		tests := []struct {
+			desc             string
+			idempotencyKey   string
+			expectedAttempts int
+		}{
+			{
+				desc:             "should not retry a plain POST",
+				expectedAttempts: 1,
+			},
+			{
+				desc:             "should retry a POST with an Idempotency-Key",
+				idempotencyKey:   "Idempotency-Key",
+				expectedAttempts: 3,
+			},
+			{
+				desc:             "should retry a POST with an X-Idempotency-Key",
+				idempotencyKey:   "X-Idempotency-Key",
+				expectedAttempts: 3,
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.desc, func(t *testing.T) {
+				var attempts int
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					attempts++
+					if attempts < 3 {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					w.WriteHeader(http.StatusOK)
+				}))
+				defer server.Close()
+
+				var entries []logEntry
+				client := &http.Client{
+					Transport: NewTransport(TransportConfig{
+						Logger:     newMockLogger(&entries),
+						MaxRetries: 3,
+						MinBackoff: time.Millisecond,
+					}),
+				}
+
+				req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("fake-body"))
+				assert.Equal(t, nil, err)
+				if test.idempotencyKey != "" {
+					req.Header.Set(test.idempotencyKey, "fake-key")
+				}
+
+				resp, err := client.Do(req)
+				assert.Equal(t, nil, err)
+				_ = resp.Body.Close()
+
+				assert.Equal(t, test.expectedAttempts, attempts)
+			})
+		}
+	})
+
+	t.Run("should log network errors as ERROR", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		var entries []logEntry
+		client := &http.Client{
+			Transport: NewTransport(TransportConfig{Logger: newMockLogger(&entries)}),
+		}
+
+		_, err := client.Post(server.URL, "text/plain", strings.NewReader("fake-body"))
+		assert.NotEqual(t, nil, err)
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "ERROR", entries[0].level)
+		assert.Equal(t, "POST", entries[0].body["method"])
+		_, hasError := entries[0].body["error"]
+		assert.True(t, hasError)
+	})
+
+	t.Run("should capture headers and bodies with redaction and size limits", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write(append([]byte("echo: "), body...))
+		}))
+		defer server.Close()
+
+		var entries []logEntry
+		client := &http.Client{
+			Transport: NewTransport(TransportConfig{
+				Logger:              newMockLogger(&entries),
+				CaptureHeaders:      true,
+				CaptureRequestBody:  true,
+				CaptureResponseBody: true,
+				MaxBodySize:         12,
+				RedactBody: func(body []byte) []byte {
+					return bytes.ReplaceAll(body, []byte("secret"), []byte("******"))
+				},
+			}),
+		}
+
+		req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("the secret value"))
+		assert.Equal(t, nil, err)
+		req.Header.Set("Authorization", "Bearer fake-token")
+		req.Header.Set("X-Custom", "fake-value")
+		resp, err := client.Do(req)
+		assert.Equal(t, nil, err)
+
+		// The entry is only logged after the response body is consumed:
+		assert.Equal(t, 0, len(entries))
+		respBody, err := io.ReadAll(resp.Body)
+		assert.Equal(t, nil, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, "echo: the secret value", string(respBody))
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "the ****** v...(truncated)", entries[0].body["request_body"])
+		assert.Equal(t, "echo: the se...(truncated)", entries[0].body["response_body"])
+
+		requestHeaders := entries[0].body["request_headers"].(map[string]string)
+		assert.Equal(t, "[REDACTED]", requestHeaders["Authorization"])
+		assert.Equal(t, "fake-value", requestHeaders["X-Custom"])
+
+		responseHeaders := entries[0].body["response_headers"].(map[string]string)
+		assert.Equal(t, "[REDACTED]", responseHeaders["Set-Cookie"])
+		assert.Equal(t, "text/plain", responseHeaders["Content-Type"])
+	})
+}
+
+func TestMiddlewareBuffer(t *testing.T) {
+	tests := []struct {
+		desc           string
+		status         int
+		expectedTitles []string
+	}{
+		{
+			desc:           "should discard the buffered entries of successful requests",
+			status:         http.StatusOK,
+			expectedTitles: []string{"http-request"},
+		},
+		{
+			desc:           "should write the buffered entries of failed requests",
+			status:         http.StatusInternalServerError,
+			expectedTitles: []string{"debug", "info", "http-request"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var titles []string
+			client := klog.New("INFO", ParseContext)
+			client.OutputHandler = func(data *klog.LogData) {
+				titles = append(titles, data.Title)
+			}
+
+			handler := Middleware(Config{
+				Logger:     client,
+				BufferSize: 10,
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				client.Debug(r.Context(), "debug")
+				client.Info(r.Context(), "info")
+				w.WriteHeader(test.status)
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, test.expectedTitles, titles)
+		})
+	}
+}
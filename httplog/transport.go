@@ -0,0 +1,392 @@
+package httplog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/retry"
+)
+
+// TransportConfig contains the configurations for the logging Transport.
+type TransportConfig struct {
+	// Logger is used for logging the requests, it is required.
+	Logger klog.Provider
+
+	// Base is the RoundTripper used for sending the requests,
+	// defaults to http.DefaultTransport.
+	Base http.RoundTripper
+
+	// RequestIDHeader is set on the outgoing requests with the
+	// request ID stored on the context, defaults to "X-Request-ID".
+	RequestIDHeader string
+
+	// LevelFunc chooses the level of the log entry from the
+	// response status, defaults to DefaultLevelFunc.
+	//
+	// Requests that fail without a response are logged as "ERROR".
+	LevelFunc func(status int) string
+
+	// MaxRetries is the number of times requests are retried on
+	// network errors and 429, 502, 503 and 504 responses.
+	//
+	// Only requests with idempotent methods or with an Idempotency-Key
+	// or X-Idempotency-Key header are retried, and requests with a body
+	// are only retried if they have a GetBody function. Defaults to 0.
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff configure the time between retries,
+	// they default to 100ms and 2s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// CaptureHeaders adds the request and response headers to the entry.
+	CaptureHeaders bool
+
+	// RedactHeaders are the headers that have their values
+	// replaced by "[REDACTED]" when captured, defaults to
+	// Authorization, Proxy-Authorization, Cookie, Set-Cookie
+	// and X-Api-Key.
+	RedactHeaders []string
+
+	// CaptureRequestBody and CaptureResponseBody add up to
+	// MaxBodySize bytes of the bodies to the entry.
+	//
+	// When the response body is captured the entry is only
+	// logged after the body is read to the end or closed.
+	CaptureRequestBody  bool
+	CaptureResponseBody bool
+
+	// MaxBodySize defaults to 4096 bytes.
+	MaxBodySize int
+
+	// RedactBody is called with the captured bodies before they are logged.
+	RedactBody func(body []byte) []byte
+}
+
+// Transport is an http.RoundTripper that logs each request
+// using the request context, so the values stored on it,
+// e.g. the request ID, show up on the entries.
+type Transport struct {
+	config TransportConfig
+	redact map[string]bool
+}
+
+var _ http.RoundTripper = &Transport{}
+
+// NewTransport returns a Transport for the received configurations:
+//
+//	client := &http.Client{
+//		Transport: httplog.NewTransport(httplog.TransportConfig{Logger: logger}),
+//	}
+func NewTransport(config TransportConfig) *Transport {
+	if config.Logger == nil {
+		panic("httplog: missing Logger")
+	}
+	if config.Base == nil {
+		config.Base = http.DefaultTransport
+	}
+	if config.RequestIDHeader == "" {
+		config.RequestIDHeader = "X-Request-ID"
+	}
+	if config.LevelFunc == nil {
+		config.LevelFunc = DefaultLevelFunc
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 2 * time.Second
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 4096
+	}
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	}
+
+	redact := map[string]bool{}
+	for _, h := range config.RedactHeaders {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return &Transport{
+		config: config,
+		redact: redact,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	ctx := req.Context()
+
+	if requestID := RequestID(ctx); requestID != "" && req.Header.Get(t.config.RequestIDHeader) == "" {
+		req = req.Clone(ctx)
+		req.Header.Set(t.config.RequestIDHeader, requestID)
+	}
+
+	body := klog.Body{
+		"method": req.Method,
+		"host":   req.URL.Host,
+		"path":   req.URL.Path,
+	}
+	if t.config.CaptureHeaders {
+		body["request_headers"] = t.headers(req.Header)
+	}
+
+	var requestBody *capture
+	if t.config.CaptureRequestBody && req.Body != nil && req.Body != http.NoBody {
+		requestBody = &capture{limit: t.config.MaxBodySize}
+		req = t.captureRequestBody(req, requestBody)
+	}
+
+	resp, retries, err := t.send(req)
+	body["retries"] = retries
+
+	if requestBody != nil {
+		body["request_body"] = t.bodyString(requestBody)
+	}
+
+	if err != nil {
+		body["error"] = err.Error()
+		body["duration_ms"] = durationMs(start)
+		t.config.Logger.Error(ctx, "http-client-request", body)
+		return nil, err
+	}
+
+	body["status"] = resp.StatusCode
+	if t.config.CaptureHeaders {
+		body["response_headers"] = t.headers(resp.Header)
+	}
+
+	if !t.config.CaptureResponseBody {
+		body["duration_ms"] = durationMs(start)
+		t.log(ctx, resp.StatusCode, body)
+		return resp, nil
+	}
+
+	responseBody := &capture{limit: t.config.MaxBodySize}
+	resp.Body = &loggingBody{
+		ReadCloser: resp.Body,
+		capture:    responseBody,
+		finish: func() {
+			body["response_body"] = t.bodyString(responseBody)
+			body["duration_ms"] = durationMs(start)
+			t.log(ctx, resp.StatusCode, body)
+		},
+	}
+
+	return resp, nil
+}
+
+func (t *Transport) log(ctx context.Context, status int, body klog.Body) {
+	Log(t.config.Logger, ctx, t.config.LevelFunc(status), "http-client-request", body)
+}
+
+// send sends the request retrying it if allowed,
+// and returns the number of retries performed.
+func (t *Transport) send(req *http.Request) (*http.Response, int, error) {
+	attempts := 0
+	var resp *http.Response
+	err := retry.Do(req.Context(), retry.Config{
+		MaxRetries: t.maxRetries(req),
+		MinBackoff: t.config.MinBackoff,
+		MaxBackoff: t.config.MaxBackoff,
+	}, func() error {
+		attempt := req
+		if attempts > 0 {
+			if resp != nil {
+				// Drain the body of the discarded response so the connection is reused:
+				_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+				_ = resp.Body.Close()
+			}
+
+			attempt = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return retry.PermanentError{Err: err}
+				}
+				attempt.Body = body
+			}
+		}
+		attempts++
+
+		var err error
+		resp, err = t.config.Base.RoundTrip(attempt)
+		if err != nil {
+			resp = nil
+			if req.Context().Err() != nil {
+				return retry.PermanentError{Err: err}
+			}
+			return err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return retryableStatus{resp.StatusCode}
+		}
+		return nil
+	})
+
+	var statusErr retryableStatus
+	if errors.As(err, &statusErr) {
+		// Out of retries, so the last response is returned as it is:
+		err = nil
+	}
+	var permanentErr retry.PermanentError
+	if errors.As(err, &permanentErr) {
+		err = permanentErr.Err
+	}
+
+	return resp, attempts - 1, err
+}
+
+func (t *Transport) maxRetries(req *http.Request) int {
+	if t.config.MaxRetries <= 0 {
+		return -1
+	}
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && req.GetBody == nil {
+		return -1
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return t.config.MaxRetries
+	}
+
+	// Same rule used by net/http for retrying non-idempotent requests:
+	if req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != "" {
+		return t.config.MaxRetries
+	}
+	return -1
+}
+
+type retryableStatus struct {
+	status int
+}
+
+func (e retryableStatus) Error() string   { return http.StatusText(e.status) }
+func (e retryableStatus) Temporary() bool { return true }
+
+func (t *Transport) captureRequestBody(req *http.Request, c *capture) *http.Request {
+	req = req.Clone(req.Context())
+	req.Body = &loggingBody{
+		ReadCloser: req.Body,
+		capture:    c,
+	}
+
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			c.reset()
+			return &loggingBody{ReadCloser: body, capture: c}, nil
+		}
+	}
+
+	return req
+}
+
+func (t *Transport) headers(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
+		if t.redact[http.CanonicalHeaderKey(k)] {
+			headers[k] = "[REDACTED]"
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+func (t *Transport) bodyString(c *capture) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b := c.buf.Bytes()
+	if t.config.RedactBody != nil {
+		b = t.config.RedactBody(b)
+	}
+
+	s := string(b)
+	if c.truncated {
+		s += "...(truncated)"
+	}
+	return s
+}
+
+func durationMs(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+// capture keeps the first bytes read from a body.
+type capture struct {
+	mutex     sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *capture) write(p []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	room := c.limit - c.buf.Len()
+	if len(p) > room {
+		p = p[:room]
+		c.truncated = true
+	}
+	c.buf.Write(p)
+}
+
+func (c *capture) reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.buf.Reset()
+	c.truncated = false
+}
+
+// loggingBody captures the bytes read and calls
+// finish once when reaching EOF or when closed.
+type loggingBody struct {
+	io.ReadCloser
+
+	capture *capture
+	once    sync.Once
+	finish  func()
+}
+
+func (b *loggingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.write(p[:n])
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *loggingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+func (b *loggingBody) done() {
+	if b.finish == nil {
+		return
+	}
+	b.once.Do(b.finish)
+}
@@ -0,0 +1,193 @@
+// Package batch implements the batching logic shared
+// by the klog outputs that send entries over HTTP.
+package batch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+)
+
+// Entry is a single log entry waiting on a batch.
+type Entry struct {
+	Time time.Time
+	Data klog.LogData
+}
+
+// Config contains the configurations for the Batcher.
+type Config struct {
+	// Name is used as a prefix on the error messages
+	// reported by the periodic flushes.
+	Name string
+
+	// MaxEntries is the number of entries that triggers
+	// sending the batch, defaults to 1000.
+	MaxEntries int
+
+	// MaxBytes is the approximate size in bytes that triggers
+	// sending the batch, zero means no limit.
+	MaxBytes int
+
+	// MaxWait is the maximum time an entry waits
+	// on the batch before being sent, defaults to 1s.
+	MaxWait time.Duration
+
+	// CloseTimeout is the maximum time Close waits for the
+	// batches to be sent before canceling the Context,
+	// defaults to 10s.
+	CloseTimeout time.Duration
+}
+
+// Batcher groups entries into batches and sends them either when
+// they get too large or when they are too old.
+//
+// Batches are sent one at a time and in order, but new entries can
+// be added while a batch is being sent.
+type Batcher struct {
+	config Config
+	send   func(entries []Entry) error
+
+	mutex   sync.Mutex
+	entries []Entry
+	bytes   int
+	closed  bool
+
+	sendMutex sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts a Batcher that calls send for each batch.
+func New(config Config, send func(entries []Entry) error) *Batcher {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 1000
+	}
+	if config.MaxWait <= 0 {
+		config.MaxWait = time.Second
+	}
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Batcher{
+		config: config,
+		send:   send,
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go b.flushPeriodically()
+
+	return b
+}
+
+// Add copies the entry into the current batch, sending it if it
+// becomes full. The size is the approximate size of the entry.
+func (b *Batcher) Add(t time.Time, data *klog.LogData, size int) error {
+	entry := Entry{
+		Time: t,
+		Data: klog.LogData{
+			Level: data.Level,
+			Title: data.Title,
+			Body:  make(klog.Body, len(data.Body)),
+		},
+	}
+	for k, v := range data.Body {
+		entry.Data.Body[k] = v
+	}
+
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return klog.ErrOutputClosed
+	}
+
+	b.entries = append(b.entries, entry)
+	b.bytes += size
+
+	full := len(b.entries) >= b.config.MaxEntries ||
+		(b.config.MaxBytes > 0 && b.bytes >= b.config.MaxBytes)
+	b.mutex.Unlock()
+
+	if !full {
+		return nil
+	}
+	return b.Flush()
+}
+
+// Context returns the context the send function should use
+// for its requests, which is canceled if Close takes longer
+// than the CloseTimeout.
+func (b *Batcher) Context() context.Context {
+	return b.ctx
+}
+
+// Flush sends the current batch.
+func (b *Batcher) Flush() error {
+	b.sendMutex.Lock()
+	defer b.sendMutex.Unlock()
+
+	b.mutex.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.bytes = 0
+	b.mutex.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+	return b.send(entries)
+}
+
+// Close stops accepting new entries and sends the current batch,
+// waiting at most the CloseTimeout for the batches being sent.
+func (b *Batcher) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mutex.Unlock()
+
+	timer := time.AfterFunc(b.config.CloseTimeout, b.cancel)
+	defer func() {
+		timer.Stop()
+		b.cancel()
+	}()
+
+	close(b.stop)
+	<-b.done
+
+	return b.Flush()
+}
+
+func (b *Batcher) flushPeriodically() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.MaxWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := b.Flush()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error sending log entries: %s\n", b.config.Name, err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package fields parses the struct tags following the rules of
+// encoding/json, so that the klog packages converting structs
+// into maps agree on the names of their fields.
+package fields
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Field is an exported field of a struct.
+type Field struct {
+	Index     int
+	Name      string
+	OmitEmpty bool
+
+	// Inline is set for embedded structs without an explicit
+	// name, whose fields are promoted just like encoding/json does.
+	Inline bool
+
+	// Options are the options after the name on the tag, except for
+	// omitempty, and are only set for the fields using the requested
+	// tag instead of the json tag.
+	Options []string
+}
+
+// HasOption reports whether the option is set on the field.
+func (f Field) HasOption(option string) bool {
+	for _, o := range f.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+type cacheKey struct {
+	t   reflect.Type
+	tag string
+}
+
+var cache sync.Map
+
+// Of returns the exported fields of the struct type using the
+// received tag, the fields without it use the json tag instead.
+// Fields tagged with "-" are omitted.
+func Of(t reflect.Type, tag string) []Field {
+	key := cacheKey{t: t, tag: tag}
+	if fields, ok := cache.Load(key); ok {
+		return fields.([]Field)
+	}
+
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		value, hasTag := sf.Tag.Lookup(tag)
+		if !hasTag {
+			value = sf.Tag.Get("json")
+		}
+		if value == "-" {
+			continue
+		}
+
+		parts := strings.Split(value, ",")
+		f := Field{
+			Index: i,
+			Name:  parts[0],
+		}
+		for _, option := range parts[1:] {
+			switch {
+			case option == "omitempty":
+				f.OmitEmpty = true
+			case hasTag:
+				f.Options = append(f.Options, option)
+			}
+		}
+
+		if f.Name == "" {
+			f.Name = sf.Name
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			f.Inline = sf.Anonymous && ft.Kind() == reflect.Struct
+		}
+		fields = append(fields, f)
+	}
+
+	cache.Store(key, fields)
+	return fields
+}
@@ -0,0 +1,201 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Decoder reads MessagePack values from a reader.
+//
+// Maps are decoded as map[string]interface{}, integers as int64 or
+// uint64, floats as float64, str as string, bin as []byte and
+// extension types as Ext values.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next value.
+func (d *Decoder) Decode() (interface{}, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(int(b & 0x0f))
+	case b&0xf0 == 0x90:
+		return d.decodeArray(int(b & 0x0f))
+	case b&0xe0 == 0xa0:
+		return d.decodeString(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLen(b - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.readN(n)
+	case 0xca:
+		buf, err := d.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(buf))), nil
+	case 0xcb:
+		buf, err := d.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buf)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		buf, err := d.readN(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return readUint(buf), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		buf, err := d.readN(1 << (b - 0xd0))
+		if err != nil {
+			return nil, err
+		}
+		return readInt(buf), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (b - 0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLen(b - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLen(b - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLen(b - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLen(b - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported type byte 0x%x", b)
+}
+
+func (d *Decoder) decodeMap(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack: only string map keys are supported")
+		}
+
+		m[key], err = d.Decode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodeArray(n int) (interface{}, error) {
+	arr := make([]interface{}, n)
+	for i := range arr {
+		var err error
+		arr[i], err = d.Decode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+func (d *Decoder) decodeString(n int) (interface{}, error) {
+	buf, err := d.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
+
+func (d *Decoder) decodeExt(n int) (interface{}, error) {
+	t, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return Ext{Type: int8(t), Data: data}, nil
+}
+
+// readLen reads a big endian length with 1, 2 or 4 bytes
+// depending on whether sizeExp is 0, 1 or 2.
+func (d *Decoder) readLen(sizeExp byte) (int, error) {
+	buf, err := d.readN(1 << sizeExp)
+	if err != nil {
+		return 0, err
+	}
+	return int(readUint(buf)), nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	buf, err := d.readN(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+func (d *Decoder) readN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(d.r, buf)
+	return buf, err
+}
+
+func readUint(buf []byte) uint64 {
+	var u uint64
+	for _, b := range buf {
+		u = u<<8 | uint64(b)
+	}
+	return u
+}
+
+func readInt(buf []byte) int64 {
+	u := readUint(buf)
+	shift := 64 - 8*uint(len(buf))
+	return int64(u<<shift) >> shift
+}
@@ -0,0 +1,311 @@
+// Package msgpack implements the subset of the MessagePack
+// format needed by the klog outputs.
+package msgpack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// EventTime is encoded as the Fluentd EventTime extension type,
+// which keeps the nanoseconds of the timestamp.
+type EventTime time.Time
+
+// Ext is an extension type value with its type code and raw data.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// Encoder appends MessagePack encoded values to its buffer.
+type Encoder struct {
+	buf []byte
+}
+
+// Bytes returns the encoded values.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// Reset empties the buffer keeping its capacity.
+func (e *Encoder) Reset() {
+	e.buf = e.buf[:0]
+}
+
+// Encode appends the value to the buffer.
+//
+// Types without a native MessagePack representation, e.g. structs,
+// are converted using their JSON representation.
+func (e *Encoder) Encode(value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		e.buf = append(e.buf, 0xc0)
+	case bool:
+		if v {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case string:
+		e.EncodeString(v)
+	case []byte:
+		e.EncodeBinary(v)
+	case Raw:
+		e.buf = append(e.buf, v...)
+	case int:
+		e.EncodeInt(int64(v))
+	case int8:
+		e.EncodeInt(int64(v))
+	case int16:
+		e.EncodeInt(int64(v))
+	case int32:
+		e.EncodeInt(int64(v))
+	case int64:
+		e.EncodeInt(v)
+	case uint:
+		e.EncodeUint(uint64(v))
+	case uint8:
+		e.EncodeUint(uint64(v))
+	case uint16:
+		e.EncodeUint(uint64(v))
+	case uint32:
+		e.EncodeUint(uint64(v))
+	case uint64:
+		e.EncodeUint(v)
+	case float32:
+		e.buf = append(e.buf, 0xca)
+		e.appendUint32(math.Float32bits(v))
+	case float64:
+		e.buf = append(e.buf, 0xcb)
+		e.appendUint64(math.Float64bits(v))
+	case EventTime:
+		t := time.Time(v)
+		e.buf = append(e.buf, 0xd7, 0x00)
+		e.appendUint32(uint32(t.Unix()))
+		e.appendUint32(uint32(t.Nanosecond()))
+	case time.Time:
+		e.EncodeString(v.Format(time.RFC3339Nano))
+	case error:
+		e.EncodeString(v.Error())
+	case map[string]interface{}:
+		e.EncodeMapLen(len(v))
+		for _, k := range sortedKeys(v) {
+			e.EncodeString(k)
+			e.Encode(v[k])
+		}
+	case []interface{}:
+		e.EncodeArrayLen(len(v))
+		for _, item := range v {
+			e.Encode(item)
+		}
+	case json.Marshaler, fmt.Stringer:
+		e.encodeAsJSON(value)
+	default:
+		e.encodeReflect(reflect.ValueOf(value))
+	}
+}
+
+func (e *Encoder) encodeReflect(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.Encode(nil)
+			return
+		}
+		e.Encode(v.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.Encode(nil)
+			return
+		}
+		e.EncodeArrayLen(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e.Encode(v.Index(i).Interface())
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			e.encodeAsJSON(v.Interface())
+			return
+		}
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		e.EncodeMapLen(len(keys))
+		for _, k := range keys {
+			e.EncodeString(k)
+			e.Encode(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())).Interface())
+		}
+	case reflect.String:
+		e.EncodeString(v.String())
+	case reflect.Bool:
+		e.Encode(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.EncodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.EncodeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.Encode(v.Float())
+	default:
+		e.encodeAsJSON(v.Interface())
+	}
+}
+
+// encodeAsJSON converts the value to its JSON representation
+// and then encodes the decoded result, so structs are encoded
+// as maps honoring their JSON tags.
+func (e *Encoder) encodeAsJSON(value interface{}) {
+	rawJSON, err := json.Marshal(value)
+	if err != nil {
+		e.EncodeString(fmt.Sprintf("%+v", value))
+		return
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(rawJSON, &decoded)
+	if err != nil {
+		e.EncodeString(string(rawJSON))
+		return
+	}
+
+	e.Encode(decoded)
+}
+
+// EncodeString appends a str value.
+func (e *Encoder) EncodeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xda)
+		e.appendUint16(uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdb)
+		e.appendUint32(uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+// EncodeBinary appends a bin value.
+func (e *Encoder) EncodeBinary(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xc5)
+		e.appendUint16(uint16(n))
+	default:
+		e.buf = append(e.buf, 0xc6)
+		e.appendUint32(uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// EncodeInt appends an int value using the smallest representation.
+func (e *Encoder) EncodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.EncodeUint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(i))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.buf = append(e.buf, 0xd1)
+		e.appendUint16(uint16(i))
+	case i >= math.MinInt32:
+		e.buf = append(e.buf, 0xd2)
+		e.appendUint32(uint32(i))
+	default:
+		e.buf = append(e.buf, 0xd3)
+		e.appendUint64(uint64(i))
+	}
+}
+
+// EncodeUint appends an uint value using the smallest representation.
+func (e *Encoder) EncodeUint(i uint64) {
+	switch {
+	case i <= 127:
+		e.buf = append(e.buf, byte(i))
+	case i <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(i))
+	case i <= math.MaxUint16:
+		e.buf = append(e.buf, 0xcd)
+		e.appendUint16(uint16(i))
+	case i <= math.MaxUint32:
+		e.buf = append(e.buf, 0xce)
+		e.appendUint32(uint32(i))
+	default:
+		e.buf = append(e.buf, 0xcf)
+		e.appendUint64(i)
+	}
+}
+
+// EncodeArrayLen appends the header of an array with n items.
+func (e *Encoder) EncodeArrayLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xdc)
+		e.appendUint16(uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdd)
+		e.appendUint32(uint32(n))
+	}
+}
+
+// EncodeMapLen appends the header of a map with n key/value pairs.
+func (e *Encoder) EncodeMapLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, 0xde)
+		e.appendUint16(uint16(n))
+	default:
+		e.buf = append(e.buf, 0xdf)
+		e.appendUint32(uint32(n))
+	}
+}
+
+func (e *Encoder) appendUint16(i uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], i)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *Encoder) appendUint32(i uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], i)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *Encoder) appendUint64(i uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], i)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Raw contains already encoded values that
+// are appended to the buffer as they are.
+type Raw []byte
@@ -0,0 +1,61 @@
+// Package netconn implements the connection handling shared
+// by the klog outputs that write to network connections.
+package netconn
+
+import (
+	"net"
+)
+
+// Conn is a network connection that is dialed again when
+// an operation fails, since the server might have been restarted.
+//
+// It is not safe for concurrent use, the outputs are expected
+// to hold their own locks while using it.
+type Conn struct {
+	dial func() (net.Conn, error)
+	conn net.Conn
+}
+
+// Dial opens the first connection using the received dial function,
+// which is also used for reconnecting.
+func Dial(dial func() (net.Conn, error)) (*Conn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{
+		dial: dial,
+		conn: conn,
+	}, nil
+}
+
+// Get returns the current connection.
+func (c *Conn) Get() net.Conn {
+	return c.conn
+}
+
+// Do calls fn with the current connection and if it fails
+// tries again once with a new connection.
+func (c *Conn) Do(fn func(conn net.Conn) error) error {
+	err := fn(c.conn)
+	if err == nil {
+		return nil
+	}
+
+	// The error from closing a broken connection is not relevant:
+	_ = c.conn.Close()
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+
+	return fn(c.conn)
+}
+
+// Close closes the current connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,152 @@
+// Package protowire implements the subset of the protocol buffers
+// wire format needed by the klog outputs, so that they don't depend
+// on generated code.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The wire types used by the protocol buffers format.
+const (
+	VarintType  = 0
+	Fixed64Type = 1
+	BytesType   = 2
+	Fixed32Type = 5
+)
+
+// AppendTag appends the key of a field with the received number and wire type.
+func AppendTag(b []byte, num int, wireType int) []byte {
+	return AppendVarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+// AppendVarint appends v encoded as a varint.
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendVarintField appends a varint field, omitting it if v is zero.
+func AppendVarintField(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, num, VarintType)
+	return AppendVarint(b, v)
+}
+
+// AppendFixed64Field appends a fixed64 field, omitting it if v is zero.
+func AppendFixed64Field(b []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, num, Fixed64Type)
+	var fixed [8]byte
+	binary.LittleEndian.PutUint64(fixed[:], v)
+	return append(b, fixed[:]...)
+}
+
+// AppendDoubleField appends a double field, omitting it if v is zero.
+func AppendDoubleField(b []byte, num int, v float64) []byte {
+	return AppendFixed64Field(b, num, math.Float64bits(v))
+}
+
+// AppendBytesField appends a length delimited field, omitting it if v is empty.
+func AppendBytesField(b []byte, num int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return AppendMessageField(b, num, v)
+}
+
+// AppendStringField appends a string field, omitting it if v is empty.
+func AppendStringField(b []byte, num int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = AppendTag(b, num, BytesType)
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendMessageField appends an embedded message field, even if it is empty.
+func AppendMessageField(b []byte, num int, msg []byte) []byte {
+	b = AppendTag(b, num, BytesType)
+	b = AppendVarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// Field is a single decoded field, Value is either an uint64
+// for numeric wire types or a []byte for length delimited ones.
+type Field struct {
+	Num   int
+	Type  int
+	Value interface{}
+}
+
+// ErrInvalid is returned when decoding malformed messages.
+var ErrInvalid = errors.New("protowire: invalid message")
+
+// Decode splits a message into its fields.
+func Decode(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, ErrInvalid
+		}
+		b = b[n:]
+
+		field := Field{Num: int(key >> 3), Type: int(key & 7)}
+		switch field.Type {
+		case VarintType:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, ErrInvalid
+			}
+			field.Value = v
+			b = b[n:]
+		case Fixed64Type:
+			if len(b) < 8 {
+				return nil, ErrInvalid
+			}
+			field.Value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case Fixed32Type:
+			if len(b) < 4 {
+				return nil, ErrInvalid
+			}
+			field.Value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case BytesType:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, ErrInvalid
+			}
+			field.Value = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			return nil, ErrInvalid
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// AppendFixed32Field appends a fixed32 field, omitting it if v is zero.
+func AppendFixed32Field(b []byte, num int, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, num, Fixed32Type)
+	var fixed [4]byte
+	binary.LittleEndian.PutUint32(fixed[:], v)
+	return append(b, fixed[:]...)
+}
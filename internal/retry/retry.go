@@ -0,0 +1,142 @@
+// Package retry implements the retry with exponential backoff
+// logic shared by the klog outputs that send entries over HTTP.
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Config contains the configurations for retrying requests.
+type Config struct {
+	// MaxRetries defaults to 3, use a negative value to disable retries.
+	MaxRetries int
+
+	// MinBackoff defaults to 500ms.
+	MinBackoff time.Duration
+
+	// MaxBackoff defaults to 5s.
+	MaxBackoff time.Duration
+}
+
+// StatusError is returned when the server answers with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request should be retried.
+func (e StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// PermanentError wraps errors that must not be retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e PermanentError) Error() string { return e.Err.Error() }
+func (e PermanentError) Unwrap() error { return e.Err }
+
+// Do calls fn until it succeeds, the maximum number of retries is
+// reached or it returns an error that should not be retried, waiting
+// an exponentially increasing time between the attempts.
+//
+// All errors are retried except for StatusErrors that are not
+// temporary, PermanentErrors and context errors, so that network
+// errors like refused connections are retried.
+//
+// Do stops waiting and returns the last error as soon
+// as the context is canceled.
+func Do(ctx context.Context, config Config, fn func() error) error {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 500 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+
+	backoff := config.MinBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if !retryable(err) || attempt >= config.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if backoff > config.MaxBackoff {
+			backoff = config.MaxBackoff
+		}
+	}
+}
+
+func retryable(err error) bool {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+
+	var permanentErr PermanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// Post sends the body to the url and returns a StatusError
+// if the response status is not 2xx, the response body is
+// returned on success.
+func Post(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+		}
+	}
+
+	return respBody, nil
+}
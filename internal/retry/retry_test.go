@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	config := Config{
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+	}
+
+	tests := []struct {
+		desc             string
+		err              error
+		expectedAttempts int
+	}{
+		{
+			desc:             "should retry temporary status errors",
+			err:              StatusError{StatusCode: http.StatusServiceUnavailable},
+			expectedAttempts: 4,
+		},
+		{
+			desc:             "should not retry other status errors",
+			err:              StatusError{StatusCode: http.StatusBadRequest},
+			expectedAttempts: 1,
+		},
+		{
+			desc:             "should retry unknown errors",
+			err:              errors.New("fake-error"),
+			expectedAttempts: 4,
+		},
+		{
+			desc:             "should not retry permanent errors",
+			err:              PermanentError{Err: errors.New("fake-error")},
+			expectedAttempts: 1,
+		},
+		{
+			desc:             "should not retry context errors",
+			err:              context.DeadlineExceeded,
+			expectedAttempts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), config, func() error {
+				attempts++
+				return test.err
+			})
+
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.expectedAttempts, attempts)
+		})
+	}
+
+	t.Run("should retry refused connections", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		url := "http://" + listener.Addr().String()
+		assert.Equal(t, nil, listener.Close())
+
+		attempts := 0
+		err = Do(context.Background(), config, func() error {
+			attempts++
+			_, err := Post(context.Background(), http.DefaultClient, url, nil, nil)
+			return err
+		})
+
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 4, attempts)
+	})
+
+	t.Run("should stop waiting when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		start := time.Now()
+		attempts := 0
+		err := Do(ctx, Config{MinBackoff: time.Minute}, func() error {
+			attempts++
+			return errors.New("fake-error")
+		})
+
+		assert.Equal(t, errors.New("fake-error"), err)
+		assert.Equal(t, 1, attempts)
+		assert.True(t, time.Since(start) < time.Minute)
+	})
+}
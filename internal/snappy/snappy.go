@@ -0,0 +1,193 @@
+// Package snappy implements the snappy block format, which is
+// the compression expected by some of the push APIs used by the
+// klog outputs.
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tagLiteral = 0x00
+	tagCopy2   = 0x02
+
+	minMatch  = 4
+	maxOffset = 1<<16 - 1
+	hashBits  = 14
+)
+
+// Encode returns the snappy block encoding of src.
+//
+// It uses a simple greedy matcher, which is not as effective
+// as the reference implementation but produces valid blocks
+// that any snappy decoder accepts.
+func Encode(src []byte) []byte {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(src)))
+	dst := append([]byte(nil), header[:n]...)
+
+	var table [1 << hashBits]int
+	for i := range table {
+		table[i] = -1
+	}
+
+	literalStart := 0
+	i := 0
+	for i+minMatch <= len(src) {
+		h := hash(src[i:])
+		candidate := table[h]
+		table[h] = i
+
+		if candidate < 0 || i-candidate > maxOffset || !equal4(src[candidate:], src[i:]) {
+			i++
+			continue
+		}
+
+		dst = appendLiteral(dst, src[literalStart:i])
+
+		length := minMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = appendCopy(dst, i-candidate, length)
+
+		i += length
+		literalStart = i
+	}
+
+	return appendLiteral(dst, src[literalStart:])
+}
+
+// Decode returns the decoded form of the snappy block src.
+func Decode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case tagLiteral:
+			l := int(tag >> 2)
+			src = src[1:]
+			if l >= 60 {
+				size := l - 59
+				if len(src) < size {
+					return nil, ErrCorrupt
+				}
+				l = 0
+				for j := size - 1; j >= 0; j-- {
+					l = l<<8 | int(src[j])
+				}
+				src = src[size:]
+			}
+			l++
+			if len(src) < l {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[:l]...)
+			src = src[l:]
+			continue
+
+		case 0x01:
+			if len(src) < 2 {
+				return nil, ErrCorrupt
+			}
+			l := 4 + int(tag>>2)&0x07
+			offset := int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+			if !copyMatch(&dst, offset, l) {
+				return nil, ErrCorrupt
+			}
+
+		case tagCopy2:
+			if len(src) < 3 {
+				return nil, ErrCorrupt
+			}
+			l := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+			if !copyMatch(&dst, offset, l) {
+				return nil, ErrCorrupt
+			}
+
+		default:
+			if len(src) < 5 {
+				return nil, ErrCorrupt
+			}
+			l := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+			if !copyMatch(&dst, offset, l) {
+				return nil, ErrCorrupt
+			}
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
+
+// ErrCorrupt is returned when decoding invalid blocks.
+var ErrCorrupt = errors.New("snappy: corrupt input")
+
+func copyMatch(dst *[]byte, offset int, length int) bool {
+	if offset <= 0 || offset > len(*dst) {
+		return false
+	}
+	start := len(*dst) - offset
+	for k := 0; k < length; k++ {
+		*dst = append(*dst, (*dst)[start+k])
+	}
+	return true
+}
+
+func appendLiteral(dst []byte, lit []byte) []byte {
+	for len(lit) > 0 {
+		chunk := lit
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+		lit = lit[len(chunk):]
+
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			dst = append(dst, byte(n)<<2|tagLiteral)
+		case n < 1<<8:
+			dst = append(dst, 60<<2|tagLiteral, byte(n))
+		default:
+			dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+		}
+		dst = append(dst, chunk...)
+	}
+	return dst
+}
+
+func appendCopy(dst []byte, offset int, length int) []byte {
+	for length > 0 {
+		l := length
+		if l > 64 {
+			l = 64
+		}
+		length -= l
+
+		dst = append(dst, byte(l-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return dst
+}
+
+func hash(b []byte) uint32 {
+	v := binary.LittleEndian.Uint32(b)
+	return (v * 0x1e35a7bd) >> (32 - hashBits)
+}
+
+func equal4(a []byte, b []byte) bool {
+	return a[0] == b[0] && a[1] == b[1] && a[2] == b[2] && a[3] == b[3]
+}
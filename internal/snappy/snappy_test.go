@@ -0,0 +1,54 @@
+package snappy
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(42)).Read(random)
+
+	tests := []struct {
+		desc  string
+		input []byte
+	}{
+		{
+			desc:  "should work with empty inputs",
+			input: []byte{},
+		},
+		{
+			desc:  "should work with short inputs",
+			input: []byte("abc"),
+		},
+		{
+			desc:  "should work with repetitive inputs",
+			input: []byte(strings.Repeat(`{"level":"INFO","title":"fake-title"}`, 1000)),
+		},
+		{
+			desc:  "should work with long runs of the same byte",
+			input: []byte(strings.Repeat("x", 100000)),
+		},
+		{
+			desc:  "should work with incompressible inputs",
+			input: random,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			encoded := Encode(test.input)
+
+			decoded, err := Decode(encoded)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, string(test.input), string(decoded))
+		})
+	}
+
+	t.Run("should compress repetitive inputs", func(t *testing.T) {
+		input := []byte(strings.Repeat(`{"level":"INFO","title":"fake-title"}`, 1000))
+		assert.True(t, len(Encode(input)) < len(input)/10)
+	})
+}
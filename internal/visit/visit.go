@@ -0,0 +1,49 @@
+// Package visit implements the cycle detection shared by
+// the klog packages that walk arbitrary values.
+package visit
+
+import "reflect"
+
+// key identifies a pointer, map or slice, the type is needed because a
+// pointer to a struct has the same address as a pointer to its first field
+// and the length because slices might share the same array.
+type key struct {
+	ptr uintptr
+	t   reflect.Type
+	len int
+}
+
+// Set holds the pointers, maps and slices being visited,
+// its zero value is ready to use.
+type Set map[key]struct{}
+
+// Enter adds the value to the set, reporting false
+// if it was already there, which means there is a cycle.
+func (s *Set) Enter(rv reflect.Value) bool {
+	if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+		return true
+	}
+
+	k := newKey(rv)
+	if _, found := (*s)[k]; found {
+		return false
+	}
+	if *s == nil {
+		*s = Set{}
+	}
+	(*s)[k] = struct{}{}
+	return true
+}
+
+// Leave removes the value from the set.
+func (s Set) Leave(rv reflect.Value) {
+	delete(s, newKey(rv))
+}
+
+func newKey(rv reflect.Value) key {
+	k := key{ptr: rv.Pointer(), t: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		k.len = rv.Len()
+	}
+	return k
+}
@@ -1,9 +1,7 @@
 package klog
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
@@ -24,6 +22,12 @@ type Client struct {
 	afterEach     []Middleware
 
 	ctxParsers []ContextParser
+
+	output   Output
+	recorder *FlightRecorder
+
+	convertOptions convertOptions
+	limits         Limits
 }
 
 // ContextParser is used for reading a log Body from the
@@ -33,23 +37,9 @@ type ContextParser func(ctx context.Context) Body
 
 // New builds a logger Client on the appropriate log level
 func New(level string, parsers ...ContextParser) *Client {
-	var priority uint
-	switch strings.ToUpper(level) {
-	case "DEBUG":
-		priority = 0
-	case "INFO":
-		priority = 1
-	case "WARN":
-		priority = 2
-	case "ERROR":
-		priority = 3
-	default:
-		priority = 1
-	}
-
 	client := &Client{
 		timeNow:       time.Now,
-		priorityLevel: priority,
+		priorityLevel: LevelPriority(level),
 		ctxParsers:    parsers,
 	}
 
@@ -60,6 +50,24 @@ func New(level string, parsers ...ContextParser) *Client {
 	return client
 }
 
+// LevelPriority converts a level name into its priority, from 0 for
+// "DEBUG" to 3 for "ERROR", defaulting to the "INFO" priority for
+// unknown levels.
+func LevelPriority(level string) uint {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return 0
+	case "INFO":
+		return 1
+	case "WARN":
+		return 2
+	case "ERROR":
+		return 3
+	default:
+		return 1
+	}
+}
+
 // AddBeforeEach adds a new middleware to the list that runs before
 // each log message gets logged.
 //
@@ -79,7 +87,7 @@ func (c *Client) AddAfterEach(m Middleware) {
 // Debug logs an entry on level "DEBUG" with the received title
 // along with all the values collected from the input valueMaps and the context.
 func (c Client) Debug(ctx context.Context, title string, valueMaps ...Body) {
-	if c.priorityLevel > 0 {
+	if !c.enabled(ctx, 0) {
 		return
 	}
 
@@ -89,7 +97,7 @@ func (c Client) Debug(ctx context.Context, title string, valueMaps ...Body) {
 // Info logs an entry on level "INFO" with the received title
 // along with all the values collected from the input valueMaps and the context.
 func (c Client) Info(ctx context.Context, title string, valueMaps ...Body) {
-	if c.priorityLevel > 1 {
+	if !c.enabled(ctx, 1) {
 		return
 	}
 
@@ -99,7 +107,7 @@ func (c Client) Info(ctx context.Context, title string, valueMaps ...Body) {
 // Warn logs an entry on level "WARN" with the received title
 // along with all the values collected from the input valueMaps and the context.
 func (c Client) Warn(ctx context.Context, title string, valueMaps ...Body) {
-	if c.priorityLevel > 2 {
+	if !c.enabled(ctx, 2) {
 		return
 	}
 
@@ -119,17 +127,82 @@ func (c Client) Error(ctx context.Context, title string, valueMaps ...Body) {
 // Fatal logs an entry on level "ERROR" with the received title
 // along with all the values collected from the input valueMaps and the context.
 //
-// After that it proceeds to exit the program with code 1.
+// After that it flushes the Output, if any was set with SetOutput,
+// and proceeds to exit the program with code 1.
 func (c Client) Fatal(ctx context.Context, title string, valueMaps ...Body) {
 	if c.priorityLevel > 3 {
 		return
 	}
 
 	c.log(ctx, "ERROR", title, valueMaps)
+	_ = c.Flush()
 	os.Exit(1)
 }
 
+// enabled reports whether entries with the received priority
+// need to be processed, which is true for entries below the level
+// of the Client if they might be recorded, or buffered in the case
+// of DEBUG and INFO entries.
+func (c Client) enabled(ctx context.Context, priority uint) bool {
+	if priority >= c.priorityLevel || c.recorder != nil {
+		return true
+	}
+	return priority <= LevelPriority("INFO") && bufferFromContext(ctx) != nil
+}
+
 func (c Client) log(ctx context.Context, level string, title string, valueMaps []Body) {
+	buffer := bufferFromContext(ctx)
+	write := LevelPriority(level) >= c.priorityLevel
+	if buffer != nil {
+		write = buffer.accepts(level, c.priorityLevel)
+	}
+	if !write {
+		if c.recorder != nil {
+			c.recordLazily(ctx, level, title, valueMaps)
+		}
+		return
+	}
+
+	t := c.now()
+	data := c.buildLogData(ctx, t, level, title, valueMaps)
+
+	var recorded *recordedEntry
+	if c.recorder != nil {
+		if level == "ERROR" {
+			// The buffered entries are written first so they
+			// are not written again as part of the flashback:
+			if buffer != nil {
+				buffer.fail()
+			}
+			c.recorder.dump(c.writeAt)
+		}
+
+		// Entries held by the buffer are only written if the request fails:
+		recorded = c.recorder.record(t, &data, buffer == nil)
+	}
+
+	if buffer != nil {
+		if buffer.hold(level, func() {
+			c.writeAt(t, &data)
+			if recorded != nil {
+				c.recorder.markWritten(recorded)
+			}
+			c.runAfterEach(ctx, &data)
+		}) {
+			return
+		}
+		if recorded != nil {
+			c.recorder.markWritten(recorded)
+		}
+	}
+
+	c.OutputHandler(&data)
+	c.runAfterEach(ctx, &data)
+}
+
+// buildLogData runs the context parsers, the conversions, the
+// beforeEach middlewares and the limits over the logged values.
+func (c Client) buildLogData(ctx context.Context, t time.Time, level string, title string, valueMaps []Body) LogData {
 	body := Body{}
 	for _, parser := range c.ctxParsers {
 		MergeMaps(&body, parser(ctx))
@@ -143,6 +216,7 @@ func (c Client) log(ctx context.Context, level string, title string, valueMaps [
 	}
 
 	normalizeLogData(&data)
+	convertBody(data.Body, c.convertOptions)
 
 	for _, m := range c.beforeEach {
 		err := m(ctx, &data)
@@ -158,17 +232,50 @@ func (c Client) log(ctx context.Context, level string, title string, valueMaps [
 		}
 	}
 
-	c.OutputHandler(&data)
+	if c.limits.enabled() {
+		c.limits.apply(t, &data)
+	}
+
+	return data
+}
+
+// recordLazily records an entry that is not going to be written,
+// copying only the logged values, so that the rest of the work
+// is only done if the entry is ever dumped by the FlightRecorder.
+func (c Client) recordLazily(ctx context.Context, level string, title string, valueMaps []Body) {
+	t := c.now()
+	body := Body{}
+	MergeMaps(&body, valueMaps...)
+
+	c.recorder.recordLazily(t, func() LogData {
+		return c.buildLogData(ctx, t, level, title, []Body{body})
+	})
+}
+
+// writeAt writes an entry that was logged earlier, using the
+// time it was logged if an Output was set with SetOutput.
+func (c Client) writeAt(t time.Time, data *LogData) {
+	if c.output == nil {
+		c.OutputHandler(data)
+		return
+	}
+
+	err := c.output.WriteLog(t, data)
+	if err != nil {
+		reportOutputError(err, data)
+	}
+}
 
+func (c Client) runAfterEach(ctx context.Context, data *LogData) {
 	for _, m := range c.afterEach {
-		err := m(ctx, &data)
+		err := m(ctx, data)
 		if err != nil {
 			c.OutputHandler(&LogData{
 				Level: "ERROR",
 				Title: "error running afterEach log middleware",
 				Body: map[string]interface{}{
 					"middlewareError": err.Error(),
-					"logData":         data,
+					"logData":         *data,
 				},
 			})
 		}
@@ -211,16 +318,3 @@ func buildJSONString(now time.Time, data *LogData) string {
 
 	return fmt.Sprint("{" + strings.Join(values, ",") + "}")
 }
-
-func escapeAsJSON(obj interface{}) string {
-	var rawJSON bytes.Buffer
-	enc := json.NewEncoder(&rawJSON)
-	enc.SetEscapeHTML(false)
-	err := enc.Encode(obj)
-	if err != nil {
-		return escapeAsJSON(fmt.Sprintf("%+v", obj))
-	}
-
-	// Remove the extra \n the Encode function adds to the output:
-	return strings.TrimSuffix(rawJSON.String(), "\n")
-}
@@ -0,0 +1,267 @@
+package klog
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits restricts the size of the log entries so that a single
+// entry can't exceed the limits of the log pipeline, zero values
+// mean no limit.
+//
+// Whenever something is truncated the "_truncated" key is set to
+// true on the Body, and truncated strings end with a marker like
+// "…(truncated 12345 bytes)".
+type Limits struct {
+	// MaxStringBytes limits the length of the strings on the Body.
+	//
+	// Byte slices are also cut to MaxStringBytes, but without the
+	// marker, so they are still encoded with the BytesEncoding.
+	MaxStringBytes int
+
+	// MaxSliceLen limits the number of items of slices and arrays,
+	// the removed items are replaced by a single marker string.
+	MaxSliceLen int
+
+	// MaxDepth limits how deep maps and slices can be nested,
+	// deeper values are replaced by a marker string.
+	MaxDepth int
+
+	// MaxKeys limits the number of keys of the Body and of the
+	// maps inside it, the keys are kept in alphabetical order.
+	MaxKeys int
+
+	// MaxLineBytes limits the size of the entry encoded as a JSON line,
+	// the largest values of the Body are truncated until it fits.
+	MaxLineBytes int
+}
+
+// TruncatedKey is the Body key set to true when any of the Limits is applied.
+const TruncatedKey = "_truncated"
+
+// SetLimits configures the size limits applied to all the entries
+// after the beforeEach middlewares run.
+func (c *Client) SetLimits(limits Limits) {
+	c.limits = limits
+}
+
+func (l Limits) enabled() bool {
+	return l != Limits{}
+}
+
+// apply truncates the entry according to the limits.
+func (l Limits) apply(t time.Time, data *LogData) {
+	truncated := false
+
+	if l.MaxStringBytes > 0 || l.MaxSliceLen > 0 || l.MaxDepth > 0 || l.MaxKeys > 0 {
+		body, changed := l.limitMap(data.Body, 1)
+		if changed {
+			data.Body = body
+			truncated = true
+		}
+	}
+
+	if truncated {
+		data.Body[TruncatedKey] = true
+	}
+
+	if l.MaxLineBytes > 0 {
+		l.limitLine(t, data)
+	}
+}
+
+func (l Limits) limitValue(value interface{}, depth int) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case string:
+		return l.limitString(v)
+	case []byte:
+		return l.limitBytes(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String || rv.IsNil() {
+			return value, false
+		}
+		if l.MaxDepth > 0 && depth >= l.MaxDepth {
+			return "…(truncated depth)", true
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+		return l.limitMap(m, depth+1)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return value, false
+		}
+		if l.MaxDepth > 0 && depth >= l.MaxDepth {
+			return "…(truncated depth)", true
+		}
+
+		n := rv.Len()
+		changed := false
+		if l.MaxSliceLen > 0 && n > l.MaxSliceLen {
+			n = l.MaxSliceLen
+			changed = true
+		}
+
+		s := make([]interface{}, 0, n+1)
+		for i := 0; i < n; i++ {
+			item, itemChanged := l.limitValue(rv.Index(i).Interface(), depth+1)
+			changed = changed || itemChanged
+			s = append(s, item)
+		}
+		if n < rv.Len() {
+			s = append(s, fmt.Sprintf("…(truncated %d items)", rv.Len()-n))
+		}
+		if !changed {
+			return value, false
+		}
+		return s, true
+	case reflect.String:
+		return l.limitString(rv.String())
+	default:
+		return value, false
+	}
+}
+
+// limitMap returns a limited copy of the map if anything was truncated.
+func (l Limits) limitMap(m map[string]interface{}, depth int) (map[string]interface{}, bool) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	changed := false
+	if l.MaxKeys > 0 && len(keys) > l.MaxKeys {
+		sort.Strings(keys)
+		keys = keys[:l.MaxKeys]
+		changed = true
+	}
+
+	limited := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		v, valueChanged := l.limitValue(m[k], depth)
+		changed = changed || valueChanged
+		limited[k] = v
+	}
+	if !changed {
+		return m, false
+	}
+	return limited, true
+}
+
+// limitLine truncates the largest values of the Body
+// until the entry fits on MaxLineBytes when encoded.
+func (l Limits) limitLine(t time.Time, data *LogData) {
+	size := len(buildJSONString(t, data))
+	if size <= l.MaxLineBytes {
+		return
+	}
+
+	if _, ok := data.Body[TruncatedKey]; !ok {
+		data.Body = copyBody(data.Body)
+		data.Body[TruncatedKey] = true
+		size += len(`,"_truncated":true`)
+	} else {
+		data.Body = copyBody(data.Body)
+	}
+
+	// Each iteration either makes a value fit or replaces it by a
+	// short marker, so this loop runs at most twice per key:
+	for i := 0; i < 2*len(data.Body) && size > l.MaxLineBytes; i++ {
+		key, encodedSize := largestValue(data.Body)
+		if key == "" {
+			break
+		}
+
+		excess := size - l.MaxLineBytes
+		var replacement interface{}
+		if s, ok := data.Body[key].(string); ok && excess < len(s) {
+			// Remove enough bytes to fit the marker as well,
+			// escaping can make the encoding grow, so the
+			// excess is estimated generously:
+			replacement, _ = truncateString(s, len(s)-excess-len(`…(truncated 0000000000 bytes)`))
+		} else {
+			replacement = fmt.Sprintf("…(truncated %d bytes)", encodedSize)
+		}
+
+		data.Body[key] = replacement
+		size += len(escapeAsJSON(replacement)) - encodedSize
+	}
+
+	if size > l.MaxLineBytes {
+		data.Title, _ = truncateString(data.Title, len(data.Title)-(size-l.MaxLineBytes)-len(`…(truncated 0000000000 bytes)`))
+	}
+}
+
+// largestValue returns the key of the Body with the largest
+// encoded value, ignoring the values that are already markers.
+func largestValue(body Body) (string, int) {
+	largestKey := ""
+	largestSize := 0
+	for k, v := range body {
+		if k == TruncatedKey {
+			continue
+		}
+		size := len(escapeAsJSON(v))
+		if size > largestSize || size == largestSize && k < largestKey {
+			largestKey = k
+			largestSize = size
+		}
+	}
+
+	// Markers are short, so there is nothing left to truncate:
+	if largestSize <= len(`"…(truncated 0000000000 bytes)"`) {
+		return "", 0
+	}
+	return largestKey, largestSize
+}
+
+func (l Limits) limitString(s string) (interface{}, bool) {
+	if l.MaxStringBytes <= 0 {
+		return s, false
+	}
+	return truncateString(s, l.MaxStringBytes)
+}
+
+func (l Limits) limitBytes(b []byte) (interface{}, bool) {
+	if l.MaxStringBytes <= 0 || len(b) <= l.MaxStringBytes {
+		return b, false
+	}
+	return b[:l.MaxStringBytes:l.MaxStringBytes], true
+}
+
+// truncateString truncates the string on a rune boundary
+// so that the content has at most max bytes, and adds the
+// marker with the number of bytes removed.
+func truncateString(s string, max int) (string, bool) {
+	if max < 0 {
+		max = 0
+	}
+	if len(s) <= max {
+		return s, false
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s…(truncated %d bytes)", s[:cut], len(s)-cut), true
+}
+
+func copyBody(body Body) Body {
+	c := make(Body, len(body)+1)
+	for k, v := range body {
+		c[k] = v
+	}
+	return c
+}
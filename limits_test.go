@@ -0,0 +1,110 @@
+package klog
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		desc           string
+		limits         Limits
+		body           Body
+		expectedOutput string
+	}{
+		{
+			desc:   "should truncate long strings on rune boundaries",
+			limits: Limits{MaxStringBytes: 5},
+			body: Body{
+				"short":  "abc",
+				"long":   "abcdefgh",
+				"nested": map[string]interface{}{"unicode": "ááá"},
+			},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"_truncated":true,"long":"abcde…(truncated 3 bytes)","nested":{"unicode":"áá…(truncated 2 bytes)"},"short":"abc"}`,
+		},
+		{
+			desc:   "should truncate long byte slices",
+			limits: Limits{MaxStringBytes: 10},
+			body:   Body{"payload": make([]byte, 3000), "short": []byte("abc")},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"_truncated":true,"payload":"AAAAAAAAAAAAAA==","short":"YWJj"}`,
+		},
+		{
+			desc:   "should limit the number of items of slices",
+			limits: Limits{MaxSliceLen: 2},
+			body:   Body{"list": []int{1, 2, 3, 4}},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"_truncated":true,"list":[1,2,"…(truncated 2 items)"]}`,
+		},
+		{
+			desc:   "should limit the nesting depth",
+			limits: Limits{MaxDepth: 2},
+			body: Body{
+				"a": map[string]interface{}{
+					"b": map[string]interface{}{"c": 1},
+					"d": 2,
+				},
+			},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"_truncated":true,"a":{"b":"…(truncated depth)","d":2}}`,
+		},
+		{
+			desc:   "should limit the number of keys",
+			limits: Limits{MaxKeys: 2},
+			body:   Body{"c": 3, "a": 1, "b": 2},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"_truncated":true,"a":1,"b":2}`,
+		},
+		{
+			desc:   "should not change entries within the limits",
+			limits: Limits{MaxStringBytes: 10, MaxSliceLen: 10, MaxDepth: 10, MaxKeys: 10, MaxLineBytes: 1000},
+			body:   Body{"key": "value", "list": []string{"a"}},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"key":"value","list":["a"]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			now := parseTime(t, "2024-10-09T09:00:00Z")
+
+			var output string
+			client := New("INFO")
+			client.OutputHandler = func(data *LogData) {
+				output = buildJSONString(now, data)
+			}
+			client.SetLimits(test.limits)
+
+			client.Info(context.TODO(), "fake-title", test.body)
+
+			assert.Equal(t, test.expectedOutput, output)
+		})
+	}
+
+	t.Run("should truncate the largest values to fit the line limit", func(t *testing.T) {
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+
+		var output string
+		client := New("INFO")
+		client.OutputHandler = func(data *LogData) {
+			output = buildJSONString(now, data)
+		}
+		client.SetLimits(Limits{MaxLineBytes: 300})
+
+		client.Info(context.TODO(), "fake-title", Body{
+			"payload": strings.Repeat("x", 10000),
+			"object":  map[string]interface{}{"data": strings.Repeat("y", 10000)},
+			"small":   "value",
+		})
+
+		assert.True(t, len(output) <= 300, "line has %d bytes", len(output))
+		assert.Contains(t, output, `"_truncated":true`)
+		assert.Contains(t, output, `"object":"…(truncated 10011 bytes)"`)
+		assert.Contains(t, output, `"small":"value"`)
+		assert.Contains(t, output, `bytes)"`)
+		assert.Contains(t, output, `"payload":"xxx`)
+	})
+}
@@ -0,0 +1,13 @@
+module github.com/vingarcia/klog/otelklog
+
+go 1.16
+
+require (
+	github.com/stretchr/testify v1.7.0
+	github.com/vingarcia/klog v0.1.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
+
+// Tests run against the klog version from this repository:
+replace github.com/vingarcia/klog => ../
@@ -0,0 +1,136 @@
+// Package otelklog integrates klog with OpenTelemetry tracing,
+// allowing logs to be correlated with the traces they belong to.
+//
+// It lives on a separate module so that the klog module
+// doesn't depend on OpenTelemetry.
+package otelklog
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/vingarcia/klog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The Body keys added by ParseSpanContext, these are
+// the same keys expected by the klog OTLP output.
+const (
+	TraceIDKey    = "trace_id"
+	SpanIDKey     = "span_id"
+	TraceFlagsKey = "trace_flags"
+)
+
+var _ klog.ContextParser = ParseSpanContext
+
+// ParseSpanContext is a klog.ContextParser that adds the trace_id,
+// span_id and trace_flags of the active span to each entry:
+//
+//	logger := klog.New("INFO", otelklog.ParseSpanContext)
+//
+// Nothing is added when there is no valid span on the context.
+func ParseSpanContext(ctx context.Context) klog.Body {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	return klog.Body{
+		TraceIDKey:    sc.TraceID().String(),
+		SpanIDKey:     sc.SpanID().String(),
+		TraceFlagsKey: sc.TraceFlags().String(),
+	}
+}
+
+// TraceID returns the trace ID of the active span, or
+// an empty string if there is no valid span on the context.
+//
+// It can be used as the IDFunc of a klog.TraceSampler so that
+// the sampling is consistent across all services of a trace.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// RecordSpanEvents returns a klog.Middleware that records each
+// entry with at least minLevel as an event on the active span,
+// using the title as the event name and the Body as its attributes.
+//
+// It should be registered with AddAfterEach so the event
+// matches what was actually logged:
+//
+//	logger.AddAfterEach(otelklog.RecordSpanEvents("INFO"))
+func RecordSpanEvents(minLevel string) klog.Middleware {
+	minPriority := klog.LevelPriority(minLevel)
+
+	return func(ctx context.Context, data *klog.LogData) error {
+		if klog.LevelPriority(data.Level) < minPriority {
+			return nil
+		}
+
+		span := trace.SpanFromContext(ctx)
+		if !span.IsRecording() {
+			return nil
+		}
+
+		attrs := make([]attribute.KeyValue, 0, len(data.Body)+1)
+		attrs = append(attrs, attribute.String("log.severity", data.Level))
+		for k, v := range data.Body {
+			// These are already part of the span itself:
+			if k == TraceIDKey || k == SpanIDKey || k == TraceFlagsKey {
+				continue
+			}
+			attrs = append(attrs, Attribute(k, v))
+		}
+
+		span.AddEvent(data.Title, trace.WithAttributes(attrs...))
+		return nil
+	}
+}
+
+// Attribute converts a Body value into an OpenTelemetry attribute,
+// values without a matching attribute type are encoded as JSON strings.
+func Attribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	case []bool:
+		return attribute.BoolSlice(key, v)
+	case []int:
+		return attribute.IntSlice(key, v)
+	case []int64:
+		return attribute.Int64Slice(key, v)
+	case []float64:
+		return attribute.Float64Slice(key, v)
+	case error:
+		return attribute.String(key, v.Error())
+	}
+
+	if value != nil {
+		rv := reflect.ValueOf(value)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+			return attribute.Int64(key, rv.Int())
+		case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			return attribute.Int64(key, int64(rv.Uint()))
+		case reflect.Float32:
+			return attribute.Float64(key, rv.Float())
+		}
+	}
+
+	return attribute.String(key, klog.EncodeJSON(value))
+}
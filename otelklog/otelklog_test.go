@@ -0,0 +1,134 @@
+package otelklog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseSpanContext(t *testing.T) {
+	t.Run("should add the span context to the body", func(t *testing.T) {
+		ctx := trace.ContextWithSpanContext(context.TODO(), newSpanContext(t))
+
+		assert.Equal(t, klog.Body{
+			"trace_id":    "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":     "00f067aa0ba902b7",
+			"trace_flags": "01",
+		}, ParseSpanContext(ctx))
+	})
+
+	t.Run("should add nothing when there is no span on the context", func(t *testing.T) {
+		assert.Equal(t, klog.Body(nil), ParseSpanContext(context.TODO()))
+	})
+
+	t.Run("should work as a parser of the Client", func(t *testing.T) {
+		ctx := trace.ContextWithSpanContext(context.TODO(), newSpanContext(t))
+
+		var body klog.Body
+		client := klog.New("INFO", ParseSpanContext)
+		client.OutputHandler = func(data *klog.LogData) {
+			body = data.Body
+		}
+
+		client.Info(ctx, "fake-title", klog.Body{"key": "value"})
+
+		assert.Equal(t, klog.Body{
+			"key":         "value",
+			"trace_id":    "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":     "00f067aa0ba902b7",
+			"trace_flags": "01",
+		}, body)
+	})
+}
+
+func TestTraceID(t *testing.T) {
+	t.Run("should return the trace ID of the active span", func(t *testing.T) {
+		ctx := trace.ContextWithSpanContext(context.TODO(), newSpanContext(t))
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(ctx))
+	})
+
+	t.Run("should return an empty string when there is no span on the context", func(t *testing.T) {
+		assert.Equal(t, "", TraceID(context.TODO()))
+	})
+}
+
+func TestRecordSpanEvents(t *testing.T) {
+	t.Run("should record the entries as events on the active span", func(t *testing.T) {
+		span := &fakeSpan{sc: newSpanContext(t)}
+		ctx := trace.ContextWithSpan(context.TODO(), span)
+
+		client := klog.New("DEBUG", ParseSpanContext)
+		client.OutputHandler = func(*klog.LogData) {}
+		client.AddAfterEach(RecordSpanEvents("INFO"))
+
+		client.Debug(ctx, "ignored-title")
+		client.Warn(ctx, "fake-title", klog.Body{"user_id": 42, "tags": []string{"a"}})
+
+		assert.Equal(t, []fakeEvent{
+			{
+				name: "fake-title",
+				attrs: attribute.NewSet(
+					attribute.String("log.severity", "WARN"),
+					attribute.Int("user_id", 42),
+					attribute.StringSlice("tags", []string{"a"}),
+				),
+			},
+		}, span.events)
+	})
+
+	t.Run("should ignore spans that are not recording", func(t *testing.T) {
+		span := &fakeSpan{sc: newSpanContext(t), notRecording: true}
+		ctx := trace.ContextWithSpan(context.TODO(), span)
+
+		err := RecordSpanEvents("DEBUG")(ctx, &klog.LogData{Level: "ERROR", Title: "fake-title"})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(span.events))
+	})
+}
+
+type fakeEvent struct {
+	name  string
+	attrs attribute.Set
+}
+
+type fakeSpan struct {
+	// Only the methods overridden below are called by the hooks:
+	trace.Span
+
+	sc           trace.SpanContext
+	notRecording bool
+	events       []fakeEvent
+}
+
+func (s *fakeSpan) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func (s *fakeSpan) IsRecording() bool {
+	return !s.notRecording
+}
+
+func (s *fakeSpan) AddEvent(name string, options ...trace.EventOption) {
+	config := trace.NewEventConfig(options...)
+	s.events = append(s.events, fakeEvent{
+		name:  name,
+		attrs: attribute.NewSet(config.Attributes()...),
+	})
+}
+
+func newSpanContext(t *testing.T) trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, nil, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	assert.Equal(t, nil, err)
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+}
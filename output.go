@@ -0,0 +1,111 @@
+package klog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output describes a destination for log entries.
+//
+// It receives the time the entry was logged along with its data,
+// so that outputs that process entries later, e.g. asynchronously,
+// can still report the correct timestamp.
+//
+// Implementations must not keep references to the data after
+// WriteLog returns since the Body might be modified by the
+// afterEach middlewares.
+type Output interface {
+	WriteLog(t time.Time, data *LogData) error
+}
+
+// OutputFunc is an adapter to allow the use of ordinary functions as outputs.
+type OutputFunc func(t time.Time, data *LogData) error
+
+// WriteLog implements the Output interface
+func (f OutputFunc) WriteLog(t time.Time, data *LogData) error {
+	return f(t, data)
+}
+
+// Flusher is implemented by outputs that buffer entries
+// and need to be flushed before the program exits.
+type Flusher interface {
+	Flush() error
+}
+
+// SetOutput configures the Client to send all log entries to
+// the received Output, replacing the current OutputHandler.
+//
+// Errors returned by the Output are reported on stderr since
+// the Output itself is not reliable at that point.
+func (c *Client) SetOutput(o Output) {
+	c.output = o
+	c.OutputHandler = func(data *LogData) {
+		err := o.WriteLog(c.now(), data)
+		if err != nil {
+			reportOutputError(err, data)
+		}
+	}
+}
+
+// Flush flushes the configured Output if it implements the Flusher interface.
+func (c Client) Flush() error {
+	f, ok := c.output.(Flusher)
+	if !ok {
+		return nil
+	}
+	return f.Flush()
+}
+
+func (c Client) now() time.Time {
+	if c.timeNow == nil {
+		return time.Now()
+	}
+	return c.timeNow()
+}
+
+// NewJSONOutput returns an Output that writes each entry
+// as a single JSON line on the received writer.
+//
+// This is the same format used by the default OutputHandler.
+func NewJSONOutput(w io.Writer) Output {
+	var mutex sync.Mutex
+	return OutputFunc(func(t time.Time, data *LogData) error {
+		line := FormatJSON(t, data) + "\n"
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		_, err := io.WriteString(w, line)
+		return err
+	})
+}
+
+// FormatJSON formats the log entry as a single JSON line
+// without the trailing line break.
+func FormatJSON(t time.Time, data *LogData) string {
+	return buildJSONString(t, data)
+}
+
+// EncodeJSON encodes a single value as JSON the same way
+// the values of the Body are encoded by the default output.
+func EncodeJSON(value interface{}) string {
+	return escapeAsJSON(value)
+}
+
+var stderrMutex sync.Mutex
+
+func reportOutputError(err error, data *LogData) {
+	stderrMutex.Lock()
+	defer stderrMutex.Unlock()
+
+	fmt.Fprintln(os.Stderr, buildJSONString(time.Now(), &LogData{
+		Level: "ERROR",
+		Title: "error writing log to output",
+		Body: Body{
+			"outputError": err.Error(),
+			"logData":     data,
+		},
+	}))
+}
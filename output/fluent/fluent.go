@@ -0,0 +1,305 @@
+// Package fluent implements a klog Output that sends the log
+// entries to Fluentd or Fluent Bit using the Forward protocol.
+//
+// The entries are encoded with MessagePack so the values
+// of the Body arrive with their native types.
+package fluent
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/msgpack"
+	"github.com/vingarcia/klog/internal/netconn"
+)
+
+// Mode describes how the batched entries are sent.
+type Mode int
+
+const (
+	// Forward sends the entries as an array of [time, record] pairs.
+	Forward Mode = iota
+
+	// PackedForward sends the entries as a single binary
+	// containing the concatenated [time, record] pairs.
+	PackedForward
+)
+
+// Config contains the configurations for the Fluent Output.
+type Config struct {
+	// Network is either "tcp" or "unix".
+	Network string
+	Address string
+
+	// Tag is required and is used by Fluentd for routing the entries.
+	Tag string
+
+	// Mode defaults to Forward.
+	Mode Mode
+
+	// RequireAck makes the Output wait for the server to acknowledge
+	// each batch of entries, retrying once on a new connection if
+	// the acknowledgment doesn't arrive.
+	RequireAck bool
+
+	// BatchSize is the number of entries that triggers
+	// sending the batch, defaults to 100.
+	BatchSize int
+
+	// MaxPendingEntries is the maximum number of entries kept for
+	// the next attempt when sending a batch fails, after which
+	// the entries are dropped, defaults to 10 times the BatchSize.
+	MaxPendingEntries int
+
+	// FlushInterval is the maximum time an entry waits
+	// on the batch before being sent, defaults to 1s.
+	FlushInterval time.Duration
+
+	// Timeout is used for dialing, writing and waiting
+	// for acknowledgments, defaults to 5s.
+	Timeout time.Duration
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that sends batches of entries
+// to Fluentd or Fluent Bit.
+type Output struct {
+	config Config
+
+	mutex   sync.Mutex
+	conn    *netconn.Conn
+	entries msgpack.Encoder
+	count   int
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New dials the configured Fluentd server and returns an Output for it.
+func New(config Config) (*Output, error) {
+	if config.Tag == "" {
+		return nil, errors.New("fluent: missing Tag")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.MaxPendingEntries < config.BatchSize {
+		config.MaxPendingEntries = 10 * config.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	o := &Output{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	conn, err := netconn.Dial(o.dial)
+	if err != nil {
+		return nil, err
+	}
+	o.conn = conn
+
+	go o.flushPeriodically()
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface, adding the
+// entry to the current batch and sending it if it is full.
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return klog.ErrOutputClosed
+	}
+
+	o.entries.EncodeArrayLen(2)
+	o.entries.Encode(msgpack.EventTime(t))
+	o.entries.Encode(Record(data))
+	o.count++
+
+	// While the batches are failing the entries are kept,
+	// so a new attempt is only made for each new full batch:
+	if o.count%o.config.BatchSize != 0 {
+		return nil
+	}
+
+	return o.flush()
+}
+
+// Flush sends the current batch.
+func (o *Output) Flush() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.flush()
+}
+
+// Close sends the current batch and closes the connection.
+func (o *Output) Close() error {
+	o.mutex.Lock()
+	if o.closed {
+		o.mutex.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mutex.Unlock()
+
+	close(o.stop)
+	<-o.done
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	err := o.flush()
+	if closeErr := o.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Record builds the Fluentd record for the received entry,
+// containing the level, the title and all the values of the Body.
+func Record(data *klog.LogData) map[string]interface{} {
+	record := make(map[string]interface{}, len(data.Body)+2)
+	for k, v := range data.Body {
+		record[k] = v
+	}
+	record["level"] = data.Level
+	record["title"] = data.Title
+	return record
+}
+
+func (o *Output) flushPeriodically() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := o.Flush()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fluent: error sending log entries: %s\n", err)
+			}
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+func (o *Output) flush() error {
+	if o.count == 0 {
+		return nil
+	}
+
+	var chunk string
+	if o.config.RequireAck {
+		var err error
+		chunk, err = newChunkID()
+		if err != nil {
+			return err
+		}
+	}
+
+	msg := o.buildMessage(chunk)
+	err := o.conn.Do(func(conn net.Conn) error {
+		return o.send(conn, msg, chunk)
+	})
+	if err != nil && o.count < o.config.MaxPendingEntries {
+		return err
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%w, dropping %d entries", err, o.count)
+	}
+	o.entries.Reset()
+	o.count = 0
+
+	return err
+}
+
+func (o *Output) buildMessage(chunk string) []byte {
+	var msg msgpack.Encoder
+	msg.EncodeArrayLen(3)
+	msg.EncodeString(o.config.Tag)
+
+	if o.config.Mode == PackedForward {
+		msg.EncodeBinary(o.entries.Bytes())
+	} else {
+		msg.EncodeArrayLen(o.count)
+		msg.Encode(msgpack.Raw(o.entries.Bytes()))
+	}
+
+	option := map[string]interface{}{
+		"size": o.count,
+	}
+	if chunk != "" {
+		option["chunk"] = chunk
+	}
+	msg.Encode(option)
+
+	return msg.Bytes()
+}
+
+func (o *Output) send(conn net.Conn, msg []byte, chunk string) error {
+	err := conn.SetDeadline(time.Now().Add(o.config.Timeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(msg)
+	if err != nil {
+		return fmt.Errorf("fluent: error sending log entries: %w", err)
+	}
+
+	if chunk == "" {
+		return nil
+	}
+
+	resp, err := msgpack.NewDecoder(conn).Decode()
+	if err != nil {
+		return fmt.Errorf("fluent: error reading acknowledgment: %w", err)
+	}
+
+	m, _ := resp.(map[string]interface{})
+	if m["ack"] != chunk {
+		return fmt.Errorf("fluent: unexpected acknowledgment: %v", resp)
+	}
+
+	return nil
+}
+
+func (o *Output) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(o.config.Network, o.config.Address, o.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("fluent: error connecting to '%s': %w", o.config.Address, err)
+	}
+	return conn, nil
+}
+
+func newChunkID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("fluent: error generating chunk id: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(id), nil
+}
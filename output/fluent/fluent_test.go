@@ -0,0 +1,224 @@
+package fluent
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/msgpack"
+)
+
+func TestOutput(t *testing.T) {
+	now := time.Unix(1728464400, 123456789)
+	entries := []klog.LogData{
+		{
+			Level: "INFO",
+			Title: "first-title",
+			Body: klog.Body{
+				"user_id": 42,
+				"ratio":   0.5,
+				"tags":    []string{"a", "b"},
+			},
+		},
+		{
+			Level: "ERROR",
+			Title: "second-title",
+		},
+	}
+	expectedEntries := []interface{}{
+		[]interface{}{
+			msgpack.Ext{Type: 0, Data: []byte{0x67, 0x06, 0x46, 0x10, 0x07, 0x5b, 0xcd, 0x15}},
+			map[string]interface{}{
+				"level":   "INFO",
+				"title":   "first-title",
+				"user_id": int64(42),
+				"ratio":   0.5,
+				"tags":    []interface{}{"a", "b"},
+			},
+		},
+		[]interface{}{
+			msgpack.Ext{Type: 0, Data: []byte{0x67, 0x06, 0x46, 0x10, 0x07, 0x5b, 0xcd, 0x15}},
+			map[string]interface{}{
+				"level": "ERROR",
+				"title": "second-title",
+			},
+		},
+	}
+
+	tests := []struct {
+		desc       string
+		mode       Mode
+		requireAck bool
+	}{
+		{
+			desc: "should send the entries in Forward mode",
+			mode: Forward,
+		},
+		{
+			desc: "should send the entries in PackedForward mode",
+			mode: PackedForward,
+		},
+		{
+			desc:       "should wait for acknowledgments when RequireAck is set",
+			mode:       Forward,
+			requireAck: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.Equal(t, nil, err)
+			defer func() { _ = listener.Close() }()
+
+			messages := make(chan []interface{}, 1)
+			go serveForward(t, listener, test.requireAck, messages)
+
+			output, err := New(Config{
+				Network:    "tcp",
+				Address:    listener.Addr().String(),
+				Tag:        "fake.tag",
+				Mode:       test.mode,
+				RequireAck: test.requireAck,
+				BatchSize:  2,
+			})
+			assert.Equal(t, nil, err)
+			defer func() { _ = output.Close() }()
+
+			for i := range entries {
+				err = output.WriteLog(now, &entries[i])
+				assert.Equal(t, nil, err)
+			}
+
+			var msg []interface{}
+			select {
+			case msg = <-messages:
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for message")
+			}
+
+			assert.Equal(t, 3, len(msg))
+			assert.Equal(t, "fake.tag", msg[0])
+
+			if test.mode == PackedForward {
+				packed, ok := msg[1].([]byte)
+				assert.Equal(t, true, ok)
+				assert.Equal(t, expectedEntries, decodeAll(t, packed))
+			} else {
+				assert.Equal(t, expectedEntries, msg[1])
+			}
+
+			option := msg[2].(map[string]interface{})
+			assert.Equal(t, int64(2), option["size"])
+			_, hasChunk := option["chunk"]
+			assert.Equal(t, test.requireAck, hasChunk)
+		})
+	}
+
+	t.Run("should send the pending entries on Close", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = listener.Close() }()
+
+		messages := make(chan []interface{}, 1)
+		go serveForward(t, listener, false, messages)
+
+		output, err := New(Config{
+			Network: "tcp",
+			Address: listener.Addr().String(),
+			Tag:     "fake.tag",
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &entries[1])
+		assert.Equal(t, nil, err)
+
+		err = output.Close()
+		assert.Equal(t, nil, err)
+
+		msg := <-messages
+		assert.Equal(t, expectedEntries[1:], msg[1])
+	})
+
+	t.Run("should keep the entries for the next attempt when sending fails", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = listener.Close() }()
+
+		messages := make(chan []interface{}, 1)
+		go func() {
+			// Both the first attempt and its retry are closed without an ack:
+			for i := 0; i < 2; i++ {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				_ = conn.Close()
+			}
+			serveForward(t, listener, true, messages)
+		}()
+
+		output, err := New(Config{
+			Network:    "tcp",
+			Address:    listener.Addr().String(),
+			Tag:        "fake.tag",
+			RequireAck: true,
+			BatchSize:  1,
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		err = output.WriteLog(now, &entries[0])
+		assert.NotEqual(t, nil, err)
+
+		err = output.WriteLog(now, &entries[1])
+		assert.Equal(t, nil, err)
+
+		msg := <-messages
+		assert.Equal(t, expectedEntries, msg[1])
+		assert.Equal(t, int64(2), msg[2].(map[string]interface{})["size"])
+	})
+}
+
+// serveForward accepts a single connection, decodes one message
+// and acknowledges it if requested.
+func serveForward(t *testing.T, listener net.Listener, ack bool, messages chan<- []interface{}) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	value, err := msgpack.NewDecoder(conn).Decode()
+	if err != nil {
+		t.Errorf("unable to decode message: %s", err)
+		return
+	}
+	msg := value.([]interface{})
+
+	if ack {
+		var enc msgpack.Encoder
+		enc.Encode(map[string]interface{}{
+			"ack": msg[2].(map[string]interface{})["chunk"],
+		})
+		_, _ = conn.Write(enc.Bytes())
+	}
+
+	messages <- msg
+}
+
+func decodeAll(t *testing.T, b []byte) []interface{} {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+
+	var values []interface{}
+	for {
+		v, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		values = append(values, v)
+	}
+	return values
+}
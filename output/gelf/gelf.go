@@ -0,0 +1,314 @@
+// Package gelf implements a klog Output that sends the log
+// entries to Graylog using the GELF 1.1 format over UDP or TCP.
+//
+// The title is sent as the `short_message` and each Body key
+// is sent as an additional field prefixed with an underscore.
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/netconn"
+	"github.com/vingarcia/klog/output/syslog"
+)
+
+// Compression describes how the messages are compressed
+// before being sent over UDP.
+type Compression int
+
+const (
+	// NoCompression sends the messages as plain JSON.
+	NoCompression Compression = iota
+	// Gzip compresses the messages using gzip.
+	Gzip
+	// Zlib compresses the messages using zlib.
+	Zlib
+)
+
+const (
+	maxChunks = 128
+	// chunkHeaderSize is the size of the magic bytes,
+	// the message ID, the sequence number and the sequence count.
+	chunkHeaderSize = 2 + 8 + 1 + 1
+)
+
+// Config contains the configurations for the GELF Output.
+type Config struct {
+	// Network is either "udp" or "tcp".
+	Network string
+	Address string
+
+	// Host defaults to os.Hostname().
+	Host string
+
+	// Compression is only used over UDP since Graylog
+	// doesn't support compression over TCP.
+	Compression Compression
+
+	// ChunkSize is the maximum size of each UDP datagram,
+	// defaults to 1420 bytes.
+	ChunkSize int
+
+	// Timeout is used both for dialing and for writing, defaults to 5s.
+	Timeout time.Duration
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that writes GELF messages to Graylog,
+// reconnecting automatically on write errors.
+type Output struct {
+	config Config
+
+	mutex sync.Mutex
+	conn  *netconn.Conn
+}
+
+// New dials the configured Graylog input and returns an Output for it.
+func New(config Config) (*Output, error) {
+	if config.Network != "udp" && config.Network != "tcp" {
+		return nil, fmt.Errorf("gelf: unsupported network '%s', expected 'udp' or 'tcp'", config.Network)
+	}
+	if config.Host == "" {
+		config.Host, _ = os.Hostname()
+	}
+	if config.ChunkSize <= chunkHeaderSize {
+		config.ChunkSize = 1420
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	o := &Output{
+		config: config,
+	}
+
+	conn, err := netconn.Dial(o.dial)
+	if err != nil {
+		return nil, err
+	}
+	o.conn = conn
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	msg, err := Encode(o.config.Host, t, data)
+	if err != nil {
+		return err
+	}
+
+	if o.config.Network == "udp" {
+		msg, err = compress(o.config.Compression, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		return klog.ErrOutputClosed
+	}
+
+	if o.config.Network == "udp" {
+		return o.write(o.conn.Get(), msg)
+	}
+
+	return o.conn.Do(func(conn net.Conn) error {
+		return o.write(conn, msg)
+	})
+}
+
+// Close closes the connection with Graylog.
+func (o *Output) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+func (o *Output) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(o.config.Network, o.config.Address, o.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("gelf: error connecting to '%s': %w", o.config.Address, err)
+	}
+	return conn, nil
+}
+
+func (o *Output) write(conn net.Conn, msg []byte) error {
+	err := conn.SetWriteDeadline(time.Now().Add(o.config.Timeout))
+	if err != nil {
+		return err
+	}
+
+	if o.config.Network == "tcp" {
+		_, err = conn.Write(append(msg, 0))
+		return err
+	}
+
+	if len(msg) <= o.config.ChunkSize {
+		_, err = conn.Write(msg)
+		return err
+	}
+
+	chunks, err := chunk(msg, o.config.ChunkSize)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		_, err = conn.Write(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Encode builds the GELF 1.1 JSON message for the received entry.
+func Encode(host string, t time.Time, data *klog.LogData) ([]byte, error) {
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": data.Title,
+		"timestamp":     float64(t.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         syslog.Severity(data.Level),
+	}
+
+	for k, v := range data.Body {
+		msg[FieldName(k)] = fieldValue(v)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("gelf: error encoding message: %w", err)
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+var invalidFieldChars = regexp.MustCompile(`[^\w.\-]`)
+
+// FieldName converts a Body key into a valid GELF additional
+// field name, i.e. prefixed with an underscore and containing
+// only letters, digits, underscores, dashes and dots.
+//
+// Since `_id` is reserved by Graylog it is renamed to `_body_id`.
+func FieldName(key string) string {
+	name := "_" + invalidFieldChars.ReplaceAllString(key, "_")
+	if name == "_id" {
+		name = "_body_id"
+	}
+	return name
+}
+
+// fieldValue keeps numbers and strings as they are,
+// since these are the only types GELF accepts,
+// and encodes all other values as JSON strings.
+//
+// NaN and infinities are not valid JSON numbers so they are
+// sent as the strings "NaN", "+Inf" and "-Inf" like klog.EncodeJSON does.
+func fieldValue(value interface{}) interface{} {
+	if value == nil {
+		return klog.EncodeJSON(value)
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value
+	case reflect.Float32, reflect.Float64:
+		f := reflect.ValueOf(value).Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return value
+	default:
+		return klog.EncodeJSON(value)
+	}
+}
+
+func compress(compression Compression, msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Zlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+
+	_, err := w.Write(msg)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gelf: error compressing message: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// chunk splits the message into GELF chunks with at most chunkSize bytes each.
+func chunk(msg []byte, chunkSize int) ([][]byte, error) {
+	dataSize := chunkSize - chunkHeaderSize
+	count := (len(msg) + dataSize - 1) / dataSize
+	if count > maxChunks {
+		return nil, errors.New("gelf: message is too large to be sent over UDP")
+	}
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("gelf: error generating message id: %w", err)
+	}
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		c := make([]byte, 0, chunkHeaderSize+end-i*dataSize)
+		c = append(c, 0x1e, 0x0f)
+		c = append(c, id...)
+		c = append(c, byte(i), byte(count))
+		c = append(c, msg[i*dataSize:end]...)
+		chunks = append(chunks, c)
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,200 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+func TestEncode(t *testing.T) {
+	t.Run("should send the body keys as additional fields", func(t *testing.T) {
+		msg, err := Encode("fake-host", parseTime(t, "2024-10-09T09:00:00.123Z"), &klog.LogData{
+			Level: "WARN",
+			Title: "fake-title",
+			Body: klog.Body{
+				"id":         42,
+				"user name":  "<name>",
+				"list":       []int{1, 2},
+				"nilPointer": nil,
+			},
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"_body_id":42,"_list":"[1,2]","_nilPointer":"null","_user_name":"<name>","host":"fake-host","level":4,"short_message":"fake-title","timestamp":1728464400.123,"version":"1.1"}`, string(msg))
+	})
+
+	t.Run("should send NaN and infinities as strings", func(t *testing.T) {
+		msg, err := Encode("fake-host", parseTime(t, "2024-10-09T09:00:00.123Z"), &klog.LogData{
+			Level: "INFO",
+			Title: "fake-title",
+			Body: klog.Body{
+				"nan":    math.NaN(),
+				"posInf": math.Inf(1),
+				"negInf": float32(math.Inf(-1)),
+				"float":  1.5,
+			},
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"_float":1.5,"_nan":"NaN","_negInf":"-Inf","_posInf":"+Inf","host":"fake-host","level":6,"short_message":"fake-title","timestamp":1728464400.123,"version":"1.1"}`, string(msg))
+	})
+}
+
+func TestOutput(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+	data := klog.LogData{
+		Level: "ERROR",
+		Title: "fake-title",
+		Body: klog.Body{
+			"payload": strings.Repeat("x", 3000),
+		},
+	}
+	expectedMsg, err := Encode("fake-host", now, &data)
+	assert.Equal(t, nil, err)
+
+	tests := []struct {
+		desc        string
+		compression Compression
+		decompress  func(io.Reader) (io.Reader, error)
+	}{
+		{
+			desc:        "should send chunked messages over UDP",
+			compression: NoCompression,
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return r, nil
+			},
+		},
+		{
+			desc:        "should send gzip compressed messages over UDP",
+			compression: Gzip,
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			desc:        "should send zlib compressed messages over UDP",
+			compression: Zlib,
+			decompress: func(r io.Reader) (io.Reader, error) {
+				return zlib.NewReader(r)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			assert.Equal(t, nil, err)
+			defer func() { _ = conn.Close() }()
+
+			output, err := New(Config{
+				Network:     "udp",
+				Address:     conn.LocalAddr().String(),
+				Host:        "fake-host",
+				Compression: test.compression,
+				ChunkSize:   100,
+			})
+			assert.Equal(t, nil, err)
+			defer func() { _ = output.Close() }()
+
+			err = output.WriteLog(now, &data)
+			assert.Equal(t, nil, err)
+
+			r, err := test.decompress(bytes.NewReader(readChunkedMessage(t, conn)))
+			assert.Equal(t, nil, err)
+			msg, err := io.ReadAll(r)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, string(expectedMsg), string(msg))
+		})
+	}
+
+	t.Run("should send null byte delimited messages over TCP", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = listener.Close() }()
+
+		output, err := New(Config{
+			Network: "tcp",
+			Address: listener.Addr().String(),
+			Host:    "fake-host",
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		serverConn, err := listener.Accept()
+		assert.Equal(t, nil, err)
+		defer func() { _ = serverConn.Close() }()
+
+		err = output.WriteLog(now, &data)
+		assert.Equal(t, nil, err)
+
+		_ = serverConn.SetReadDeadline(time.Now().Add(time.Second))
+		msg, err := bufio.NewReader(serverConn).ReadBytes(0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, string(expectedMsg)+"\x00", string(msg))
+	})
+
+	t.Run("should fail for messages with more than 128 chunks", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = conn.Close() }()
+
+		output, err := New(Config{
+			Network:   "udp",
+			Address:   conn.LocalAddr().String(),
+			ChunkSize: 20,
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		err = output.WriteLog(now, &data)
+		assert.NotEqual(t, nil, err)
+	})
+}
+
+// readChunkedMessage reads all the chunks of a single message
+// and returns the reassembled payload, messages that fit on
+// a single datagram are returned as they are.
+func readChunkedMessage(t *testing.T, conn net.PacketConn) []byte {
+	var chunks [][]byte
+	var count int
+	for count == 0 || len(chunks) < count {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64*1024)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unable to read chunk: %s", err)
+		}
+		if n > 100 {
+			t.Fatalf("chunk is larger than the chunk size: %d", n)
+		}
+		if buf[0] != 0x1e || buf[1] != 0x0f {
+			if chunks != nil {
+				t.Fatalf("missing chunk magic bytes")
+			}
+			return buf[:n]
+		}
+
+		if chunks == nil {
+			count = int(buf[11])
+			chunks = make([][]byte, 0, count)
+		}
+		chunks = append(chunks, buf[chunkHeaderSize:n])
+	}
+
+	return bytes.Join(chunks, nil)
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
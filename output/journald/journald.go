@@ -0,0 +1,210 @@
+// Package journald implements a klog Output that sends the log
+// entries to systemd-journald using its native protocol, so that
+// each Body key becomes a journal field.
+//
+// When the journald socket is not available, either when the Output
+// is created or later when writing to it fails, the entries are written
+// to stderr as JSON lines prefixed with their syslog priority, e.g.
+// `<6>{"timestamp":...}`, which is understood by systemd when it
+// is configured with `SyslogLevelPrefix=true` (the default).
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/output/syslog"
+)
+
+// DefaultSocketPath is the path of the journald native protocol socket.
+const DefaultSocketPath = "/run/systemd/journal/socket"
+
+// Config contains the optional configurations for the journald Output.
+type Config struct {
+	// SocketPath defaults to DefaultSocketPath.
+	SocketPath string
+
+	// SyslogIdentifier defaults to the name of the executable.
+	SyslogIdentifier string
+
+	// Fallback is used when the journald socket is not available,
+	// defaults to os.Stderr.
+	Fallback io.Writer
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that writes to systemd-journald.
+type Output struct {
+	identifier string
+
+	conn *net.UnixConn
+	addr *net.UnixAddr
+
+	mutex    sync.Mutex
+	fallback io.Writer
+}
+
+// New returns an Output that writes to the journald socket
+// or to the fallback writer if the socket is not available.
+func New(config Config) (*Output, error) {
+	if config.SocketPath == "" {
+		config.SocketPath = DefaultSocketPath
+	}
+	if config.SyslogIdentifier == "" {
+		config.SyslogIdentifier = filepath.Base(os.Args[0])
+	}
+	if config.Fallback == nil {
+		config.Fallback = os.Stderr
+	}
+
+	o := &Output{
+		identifier: config.SyslogIdentifier,
+		fallback:   config.Fallback,
+	}
+
+	if _, err := os.Stat(config.SocketPath); err != nil {
+		return o, nil
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: error creating socket: %w", err)
+	}
+
+	o.conn = conn
+	o.addr = &net.UnixAddr{Name: config.SocketPath, Net: "unixgram"}
+	return o, nil
+}
+
+// Available reports whether the journald socket was found when the
+// Output was created, in which case the entries are only written to
+// the fallback writer while the socket is missing.
+func (o *Output) Available() bool {
+	return o.conn != nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	priority := syslog.Severity(data.Level)
+
+	if o.conn == nil {
+		return o.writeFallback(t, priority, data)
+	}
+
+	msg := o.encode(priority, data)
+
+	_, _, err := o.conn.WriteMsgUnix(msg, nil, o.addr)
+	if err != nil && isMessageTooLong(err) {
+		// Journald accepts large entries as a file descriptor
+		// pointing to a file containing the entry:
+		err = sendViaFile(o.conn, o.addr, msg)
+	}
+	if err != nil {
+		// The socket might have been removed after New,
+		// e.g. if journald was stopped:
+		if _, statErr := os.Stat(o.addr.Name); statErr != nil {
+			return o.writeFallback(t, priority, data)
+		}
+		return fmt.Errorf("journald: error sending entry: %w", err)
+	}
+
+	return nil
+}
+
+func (o *Output) writeFallback(t time.Time, priority int, data *klog.LogData) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	_, err := io.WriteString(o.fallback, "<"+strconv.Itoa(priority)+">"+klog.FormatJSON(t, data)+"\n")
+	return err
+}
+
+// Close closes the socket used for writing to journald.
+func (o *Output) Close() error {
+	if o.conn == nil {
+		return nil
+	}
+	return o.conn.Close()
+}
+
+func (o *Output) encode(priority int, data *klog.LogData) []byte {
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", data.Title)
+	writeField(&buf, "PRIORITY", strconv.Itoa(priority))
+	writeField(&buf, "SYSLOG_IDENTIFIER", o.identifier)
+
+	keys := make([]string, 0, len(data.Body))
+	for k := range data.Body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value, ok := data.Body[k].(string)
+		if !ok {
+			value = klog.EncodeJSON(data.Body[k])
+		}
+		writeField(&buf, FieldName(k), value)
+	}
+
+	return buf.Bytes()
+}
+
+// writeField writes the field using the simple `KEY=value\n` format
+// when possible or the binary safe format for values with line breaks.
+func writeField(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// FieldName converts a Body key into a valid journal field name,
+// i.e. uppercase letters, digits and underscores, not starting
+// with a digit or an underscore and with at most 64 characters.
+//
+// Keys that would clash with the fields set by this package
+// are prefixed with "BODY_".
+func FieldName(key string) string {
+	b := []byte(strings.ToUpper(key))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+
+	name := strings.TrimLeft(string(b), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "BODY_" + name
+	}
+
+	switch name {
+	case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		name = "BODY_" + name
+	}
+
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
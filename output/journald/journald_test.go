@@ -0,0 +1,151 @@
+package journald
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+func TestOutput(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+
+	t.Run("should send the entries using the native protocol", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		assert.Equal(t, nil, err)
+		defer func() { _ = conn.Close() }()
+
+		output, err := New(Config{
+			SocketPath:       path,
+			SyslogIdentifier: "fake-app",
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+		assert.Equal(t, true, output.Available())
+
+		err = output.WriteLog(now, &klog.LogData{
+			Level: "WARN",
+			Title: "fake-title",
+			Body: klog.Body{
+				"userId":    42,
+				"multiline": "line1\nline2",
+				"message":   "clashes with MESSAGE",
+			},
+		})
+		assert.Equal(t, nil, err)
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64*1024)
+		n, _, err := conn.ReadFrom(buf)
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, strings.Join([]string{
+			"MESSAGE=fake-title\n",
+			"PRIORITY=4\n",
+			"SYSLOG_IDENTIFIER=fake-app\n",
+			"BODY_MESSAGE=clashes with MESSAGE\n",
+			"MULTILINE\n\x0b\x00\x00\x00\x00\x00\x00\x00line1\nline2\n",
+			"USERID=42\n",
+		}, ""), string(buf[:n]))
+	})
+
+	t.Run("should fallback to the writer with priority prefixes when the socket is absent", func(t *testing.T) {
+		var fallback bytes.Buffer
+		output, err := New(Config{
+			SocketPath: filepath.Join(t.TempDir(), "missing.sock"),
+			Fallback:   &fallback,
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+		assert.Equal(t, false, output.Available())
+
+		err = output.WriteLog(now, &klog.LogData{
+			Level: "ERROR",
+			Title: "fake-title",
+			Body:  klog.Body{"key": "value"},
+		})
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, `<3>{"timestamp":"2024-10-09T09:00:00Z","level":"ERROR","title":"fake-title","key":"value"}`+"\n", fallback.String())
+	})
+
+	t.Run("should fallback to the writer when the socket is removed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "journal.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		assert.Equal(t, nil, err)
+
+		var fallback bytes.Buffer
+		output, err := New(Config{
+			SocketPath: path,
+			Fallback:   &fallback,
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+		assert.Equal(t, true, output.Available())
+
+		assert.Equal(t, nil, conn.Close())
+		_ = os.Remove(path)
+
+		err = output.WriteLog(now, &klog.LogData{
+			Level: "INFO",
+			Title: "fake-title",
+		})
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, `<6>{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title"}`+"\n", fallback.String())
+	})
+}
+
+func TestFieldName(t *testing.T) {
+	tests := []struct {
+		desc         string
+		key          string
+		expectedName string
+	}{
+		{
+			desc:         "should convert keys to uppercase",
+			key:          "userId",
+			expectedName: "USERID",
+		},
+		{
+			desc:         "should replace invalid characters with underscores",
+			key:          "http.status-code",
+			expectedName: "HTTP_STATUS_CODE",
+		},
+		{
+			desc:         "should not allow leading underscores",
+			key:          "_trusted",
+			expectedName: "TRUSTED",
+		},
+		{
+			desc:         "should not allow leading digits",
+			key:          "1key",
+			expectedName: "BODY_1KEY",
+		},
+		{
+			desc:         "should limit the name to 64 characters",
+			key:          strings.Repeat("a", 100),
+			expectedName: strings.Repeat("A", 64),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedName, FieldName(test.key))
+		})
+	}
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
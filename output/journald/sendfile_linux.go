@@ -0,0 +1,38 @@
+package journald
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// sendViaFile writes the message to an unlinked temporary file
+// and sends its file descriptor to journald.
+func sendViaFile(conn *net.UnixConn, addr *net.UnixAddr, msg []byte) error {
+	file, err := os.CreateTemp("/dev/shm", "klog-journald-")
+	if err != nil {
+		file, err = os.CreateTemp("", "klog-journald-")
+		if err != nil {
+			return err
+		}
+	}
+	defer func() { _ = file.Close() }()
+
+	err = os.Remove(file.Name())
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = conn.WriteMsgUnix(nil, syscall.UnixRights(int(file.Fd())), addr)
+	return err
+}
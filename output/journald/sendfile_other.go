@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package journald
+
+import (
+	"errors"
+	"net"
+)
+
+func isMessageTooLong(err error) bool {
+	return false
+}
+
+func sendViaFile(conn *net.UnixConn, addr *net.UnixAddr, msg []byte) error {
+	return errors.New("sending large entries is only supported on linux")
+}
@@ -0,0 +1,297 @@
+// Package loki implements a klog Output that sends batches
+// of log entries to the Grafana Loki push API.
+//
+// Selected Body keys can be promoted to stream labels, in which
+// case they are removed from the log line, which is otherwise
+// the same JSON line produced by the default klog output.
+package loki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/batch"
+	"github.com/vingarcia/klog/internal/protowire"
+	"github.com/vingarcia/klog/internal/retry"
+	"github.com/vingarcia/klog/internal/snappy"
+)
+
+// PushPath is the path of the Loki push API.
+const PushPath = "/loki/api/v1/push"
+
+// Encoding describes the payload format of the push requests.
+type Encoding int
+
+const (
+	// Protobuf sends snappy compressed protobuf payloads,
+	// which is the most efficient format Loki accepts.
+	Protobuf Encoding = iota
+
+	// JSON sends uncompressed JSON payloads.
+	JSON
+)
+
+// Config contains the configurations for the Loki Output.
+type Config struct {
+	// URL is the address of the Loki server, e.g. http://localhost:3100,
+	// the PushPath is appended to it.
+	URL string
+
+	// TenantID is sent on the X-Scope-OrgID header when set.
+	TenantID string
+
+	// Labels are added to all the streams.
+	Labels map[string]string
+
+	// LabelKeys are the Body keys promoted to stream labels,
+	// the "level" key can be used to promote the entry level.
+	LabelKeys []string
+
+	// Encoding defaults to Protobuf.
+	Encoding Encoding
+
+	// BatchSize is the number of entries that triggers
+	// sending the batch, defaults to 1000.
+	BatchSize int
+
+	// BatchBytes is the approximate size of the log lines
+	// that triggers sending the batch, defaults to 1MB.
+	BatchBytes int
+
+	// BatchWait is the maximum time an entry waits on
+	// the batch before being sent, defaults to 1s.
+	BatchWait time.Duration
+
+	// CloseTimeout is the maximum time Close waits for the
+	// pending batches to be sent, defaults to 10s.
+	CloseTimeout time.Duration
+
+	// Retry configures the retries of failed requests,
+	// only 429 and 5xx responses and network errors are retried.
+	Retry retry.Config
+
+	// HTTPClient defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that sends batches of entries to Loki.
+type Output struct {
+	config  Config
+	url     string
+	batcher *batch.Batcher
+}
+
+// New returns an Output that sends the entries to Loki.
+func New(config Config) (*Output, error) {
+	if config.URL == "" {
+		return nil, errors.New("loki: missing URL")
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = 1 << 20
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	o := &Output{
+		config: config,
+		url:    strings.TrimSuffix(config.URL, "/") + PushPath,
+	}
+	o.batcher = batch.New(batch.Config{
+		Name:         "loki",
+		MaxEntries:   config.BatchSize,
+		MaxBytes:     config.BatchBytes,
+		MaxWait:      config.BatchWait,
+		CloseTimeout: config.CloseTimeout,
+	}, o.push)
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	return o.batcher.Add(t, data, len(data.Title)+32*len(data.Body))
+}
+
+// Flush sends the current batch.
+func (o *Output) Flush() error {
+	return o.batcher.Flush()
+}
+
+// Close sends the current batch and stops the Output.
+func (o *Output) Close() error {
+	return o.batcher.Close()
+}
+
+type stream struct {
+	labels  map[string]string
+	entries []streamEntry
+}
+
+type streamEntry struct {
+	time time.Time
+	line string
+}
+
+func (o *Output) push(entries []batch.Entry) error {
+	streams := o.buildStreams(entries)
+
+	var body []byte
+	headers := map[string]string{}
+	if o.config.Encoding == JSON {
+		body = encodeJSON(streams)
+		headers["Content-Type"] = "application/json"
+	} else {
+		body = snappy.Encode(encodeProtobuf(streams))
+		headers["Content-Type"] = "application/x-protobuf"
+	}
+	if o.config.TenantID != "" {
+		headers["X-Scope-OrgID"] = o.config.TenantID
+	}
+
+	ctx := o.batcher.Context()
+	err := retry.Do(ctx, o.config.Retry, func() error {
+		_, err := retry.Post(ctx, o.config.HTTPClient, o.url, headers, body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("loki: error pushing %d entries: %w", len(entries), err)
+	}
+
+	return nil
+}
+
+// buildStreams groups the entries by their labels sorting
+// them by time since Loki rejects out of order entries.
+func (o *Output) buildStreams(entries []batch.Entry) []*stream {
+	streamsByKey := map[string]*stream{}
+	var streams []*stream
+	for _, entry := range entries {
+		labels := make(map[string]string, len(o.config.Labels)+len(o.config.LabelKeys))
+		for k, v := range o.config.Labels {
+			labels[LabelName(k)] = v
+		}
+
+		data := entry.Data
+		for _, k := range o.config.LabelKeys {
+			if k == "level" {
+				labels["level"] = data.Level
+				continue
+			}
+
+			v, ok := data.Body[k]
+			if !ok {
+				continue
+			}
+			delete(data.Body, k)
+
+			s, ok := v.(string)
+			if !ok {
+				s = klog.EncodeJSON(v)
+			}
+			labels[LabelName(k)] = s
+		}
+
+		key := formatLabels(labels)
+		s, ok := streamsByKey[key]
+		if !ok {
+			s = &stream{labels: labels}
+			streamsByKey[key] = s
+			streams = append(streams, s)
+		}
+
+		s.entries = append(s.entries, streamEntry{
+			time: entry.Time,
+			line: klog.FormatJSON(entry.Time, &data),
+		})
+	}
+
+	for _, s := range streams {
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].time.Before(s.entries[j].time)
+		})
+	}
+
+	return streams
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// LabelName converts a key into a valid Loki label name.
+func LabelName(key string) string {
+	name := invalidLabelChars.ReplaceAllString(key, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// formatLabels formats the labels using the Prometheus
+// syntax expected by Loki, e.g. `{env="prod", service="api"}`.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+strconv.Quote(labels[k]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func encodeJSON(streams []*stream) []byte {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	payload := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+	for _, s := range streams {
+		js := jsonStream{Stream: s.labels}
+		for _, e := range s.entries {
+			js.Values = append(js.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+		}
+		payload.Streams = append(payload.Streams, js)
+	}
+
+	// Marshaling maps of strings and slices of strings never fails:
+	b, _ := json.Marshal(payload)
+	return b
+}
+
+// encodeProtobuf encodes the streams as a logproto.PushRequest.
+func encodeProtobuf(streams []*stream) []byte {
+	var req []byte
+	for _, s := range streams {
+		var msg []byte
+		msg = protowire.AppendStringField(msg, 1, formatLabels(s.labels))
+		for _, e := range s.entries {
+			var timestamp []byte
+			timestamp = protowire.AppendVarintField(timestamp, 1, uint64(e.time.Unix()))
+			timestamp = protowire.AppendVarintField(timestamp, 2, uint64(e.time.Nanosecond()))
+
+			var entry []byte
+			entry = protowire.AppendMessageField(entry, 1, timestamp)
+			entry = protowire.AppendStringField(entry, 2, e.line)
+
+			msg = protowire.AppendMessageField(msg, 2, entry)
+		}
+		req = protowire.AppendMessageField(req, 1, msg)
+	}
+	return req
+}
@@ -0,0 +1,259 @@
+package loki
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/protowire"
+	"github.com/vingarcia/klog/internal/retry"
+	"github.com/vingarcia/klog/internal/snappy"
+)
+
+func TestOutput(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+	entries := []klog.LogData{
+		{
+			Level: "INFO",
+			Title: "first-title",
+			Body:  klog.Body{"service": "api", "user_id": 42},
+		},
+		{
+			Level: "ERROR",
+			Title: "second-title",
+			Body:  klog.Body{"service": "api"},
+		},
+		{
+			Level: "INFO",
+			Title: "third-title",
+			Body:  klog.Body{"service": "worker"},
+		},
+	}
+	expectedStreams := map[string][]string{
+		`{env="prod", level="INFO", service="api"}`: {
+			`{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"first-title","user_id":42}`,
+		},
+		`{env="prod", level="ERROR", service="api"}`: {
+			`{"timestamp":"2024-10-09T09:00:00Z","level":"ERROR","title":"second-title"}`,
+		},
+		`{env="prod", level="INFO", service="worker"}`: {
+			`{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"third-title"}`,
+		},
+	}
+
+	tests := []struct {
+		desc          string
+		encoding      Encoding
+		decodeStreams func(t *testing.T, r *http.Request) map[string][]string
+	}{
+		{
+			desc:          "should push snappy compressed protobuf payloads",
+			encoding:      Protobuf,
+			decodeStreams: decodeProtobuf,
+		},
+		{
+			desc:          "should push JSON payloads",
+			encoding:      JSON,
+			decodeStreams: decodeJSON,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var mutex sync.Mutex
+			var streams map[string][]string
+			var tenant string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, PushPath, r.URL.Path)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				tenant = r.Header.Get("X-Scope-OrgID")
+				streams = test.decodeStreams(t, r)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			output, err := New(Config{
+				URL:       server.URL,
+				TenantID:  "fake-tenant",
+				Labels:    map[string]string{"env": "prod"},
+				LabelKeys: []string{"service", "level"},
+				Encoding:  test.encoding,
+			})
+			assert.Equal(t, nil, err)
+
+			for i := range entries {
+				err = output.WriteLog(now, &entries[i])
+				assert.Equal(t, nil, err)
+			}
+
+			err = output.Close()
+			assert.Equal(t, nil, err)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Equal(t, "fake-tenant", tenant)
+			assert.Equal(t, expectedStreams, streams)
+		})
+	}
+
+	t.Run("should send the batch when it reaches the batch size", func(t *testing.T) {
+		requests := make(chan map[string][]string, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- decodeJSON(t, r)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:       server.URL,
+			Encoding:  JSON,
+			BatchSize: 2,
+			BatchWait: time.Hour,
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		_ = output.WriteLog(now, &entries[0])
+		assert.Equal(t, 0, len(requests))
+
+		_ = output.WriteLog(now, &entries[1])
+		assert.Equal(t, 1, len(requests))
+	})
+
+	t.Run("should retry temporary failures", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:   server.URL,
+			Retry: retry.Config{MinBackoff: time.Millisecond},
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		_ = output.WriteLog(now, &entries[0])
+		err = output.Flush()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("should not retry client errors", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:   server.URL,
+			Retry: retry.Config{MinBackoff: time.Millisecond},
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		_ = output.WriteLog(now, &entries[0])
+		err = output.Flush()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("should stop retrying when Close takes longer than the CloseTimeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:          server.URL,
+			Retry:        retry.Config{MinBackoff: time.Minute},
+			CloseTimeout: 10 * time.Millisecond,
+		})
+		assert.Equal(t, nil, err)
+
+		_ = output.WriteLog(now, &entries[0])
+
+		start := time.Now()
+		err = output.Close()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, true, time.Since(start) < time.Second)
+	})
+}
+
+func decodeJSON(t *testing.T, r *http.Request) map[string][]string {
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+	var payload struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&payload)
+	assert.Equal(t, nil, err)
+
+	streams := map[string][]string{}
+	for _, s := range payload.Streams {
+		key := formatLabels(s.Stream)
+		for _, v := range s.Values {
+			streams[key] = append(streams[key], v[1])
+		}
+	}
+	return streams
+}
+
+func decodeProtobuf(t *testing.T, r *http.Request) map[string][]string {
+	assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+	compressed, err := io.ReadAll(r.Body)
+	assert.Equal(t, nil, err)
+	body, err := snappy.Decode(compressed)
+	assert.Equal(t, nil, err)
+
+	streams := map[string][]string{}
+	fields, err := protowire.Decode(body)
+	assert.Equal(t, nil, err)
+	for _, field := range fields {
+		streamFields, err := protowire.Decode(field.Value.([]byte))
+		assert.Equal(t, nil, err)
+
+		var labels string
+		var lines []string
+		for _, sf := range streamFields {
+			switch sf.Num {
+			case 1:
+				labels = string(sf.Value.([]byte))
+			case 2:
+				entryFields, err := protowire.Decode(sf.Value.([]byte))
+				assert.Equal(t, nil, err)
+				for _, ef := range entryFields {
+					if ef.Num == 2 {
+						lines = append(lines, string(ef.Value.([]byte)))
+					}
+				}
+			}
+		}
+		streams[labels] = append(streams[labels], lines...)
+	}
+	return streams
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
@@ -0,0 +1,294 @@
+// Package opensearch implements a klog Output that indexes batches
+// of log entries on Elasticsearch or OpenSearch using the `_bulk` API.
+package opensearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/batch"
+	"github.com/vingarcia/klog/internal/retry"
+)
+
+// Config contains the configurations for the OpenSearch Output.
+type Config struct {
+	// URL is the address of the cluster, e.g. http://localhost:9200.
+	URL string
+
+	// Index is the name of the index the entries are written to,
+	// it might contain a Go time layout between braces which is
+	// formatted with the entry time in UTC, e.g. `logs-{2006.01.02}`.
+	Index string
+
+	// UseCreate sends `create` instead of `index` actions,
+	// which is required when writing to data streams.
+	UseCreate bool
+
+	// Username and Password are used for basic authentication when set.
+	Username string
+	Password string
+
+	// Headers are added to all the requests.
+	Headers map[string]string
+
+	// BatchSize is the number of entries that triggers
+	// sending the batch, defaults to 1000.
+	BatchSize int
+
+	// BatchBytes is the approximate size of the entries that
+	// triggers sending the batch, defaults to 5MB.
+	BatchBytes int
+
+	// BatchWait is the maximum time an entry waits on
+	// the batch before being sent, defaults to 1s.
+	BatchWait time.Duration
+
+	// CloseTimeout is the maximum time Close waits for the
+	// pending batches to be sent, defaults to 10s.
+	CloseTimeout time.Duration
+
+	// Retry configures the retries of failed requests and failed
+	// items, only 429 and 5xx errors and network errors are retried.
+	Retry retry.Config
+
+	// HTTPClient defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+// Stats contains the counters of the OpenSearch Output.
+type Stats struct {
+	Indexed  uint64
+	Failed   uint64
+	Retried  uint64
+	Requests uint64
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that indexes batches of entries on OpenSearch.
+type Output struct {
+	config  Config
+	url     string
+	headers map[string]string
+	batcher *batch.Batcher
+
+	statsMutex sync.Mutex
+	stats      Stats
+}
+
+// New returns an Output that sends the entries to OpenSearch.
+func New(config Config) (*Output, error) {
+	if config.URL == "" {
+		return nil, errors.New("opensearch: missing URL")
+	}
+	if config.Index == "" {
+		return nil, errors.New("opensearch: missing Index")
+	}
+	if config.BatchBytes <= 0 {
+		config.BatchBytes = 5 << 20
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/x-ndjson",
+	}
+	for k, v := range config.Headers {
+		headers[k] = v
+	}
+	if config.Username != "" || config.Password != "" {
+		req, _ := http.NewRequest(http.MethodPost, config.URL, nil)
+		req.SetBasicAuth(config.Username, config.Password)
+		headers["Authorization"] = req.Header.Get("Authorization")
+	}
+
+	o := &Output{
+		config:  config,
+		url:     strings.TrimSuffix(config.URL, "/") + "/_bulk",
+		headers: headers,
+	}
+	o.batcher = batch.New(batch.Config{
+		Name:         "opensearch",
+		MaxEntries:   config.BatchSize,
+		MaxBytes:     config.BatchBytes,
+		MaxWait:      config.BatchWait,
+		CloseTimeout: config.CloseTimeout,
+	}, o.bulk)
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	return o.batcher.Add(t, data, len(data.Title)+32*len(data.Body))
+}
+
+// Flush sends the current batch.
+func (o *Output) Flush() error {
+	return o.batcher.Flush()
+}
+
+// Close sends the current batch and stops the Output.
+func (o *Output) Close() error {
+	return o.batcher.Close()
+}
+
+// Stats returns a snapshot of the counters of this output.
+func (o *Output) Stats() Stats {
+	o.statsMutex.Lock()
+	defer o.statsMutex.Unlock()
+	return o.stats
+}
+
+type bulkItem struct {
+	action   []byte
+	document []byte
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (o *Output) bulk(entries []batch.Entry) error {
+	pending := make([]bulkItem, 0, len(entries))
+	for _, entry := range entries {
+		pending = append(pending, o.buildItem(entry))
+	}
+
+	var lastItemError string
+	var failed int
+	ctx := o.batcher.Context()
+	err := retry.Do(ctx, o.config.Retry, func() error {
+		o.addStats(Stats{Requests: 1})
+
+		respBody, err := retry.Post(ctx, o.config.HTTPClient, o.url, o.headers, buildBody(pending))
+		if err != nil {
+			return err
+		}
+
+		var resp bulkResponse
+		err = json.Unmarshal(respBody, &resp)
+		if err != nil {
+			return fmt.Errorf("unable to parse bulk response: %w", err)
+		}
+		if !resp.Errors {
+			o.addStats(Stats{Indexed: uint64(len(pending))})
+			pending = nil
+			return nil
+		}
+		if len(resp.Items) != len(pending) {
+			return fmt.Errorf("bulk response has %d items but %d were sent", len(resp.Items), len(pending))
+		}
+
+		var retryable []bulkItem
+		var indexed uint64
+		for i, item := range resp.Items {
+			for _, result := range item {
+				switch {
+				case result.Status >= 200 && result.Status <= 299:
+					indexed++
+				case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+					retryable = append(retryable, pending[i])
+				default:
+					failed++
+					lastItemError = string(result.Error)
+				}
+			}
+		}
+		o.addStats(Stats{Indexed: indexed, Retried: uint64(len(retryable))})
+
+		pending = retryable
+		if len(pending) > 0 {
+			return fmt.Errorf("%d items failed temporarily", len(pending))
+		}
+		return nil
+	})
+
+	failed += len(pending)
+	o.addStats(Stats{Failed: uint64(failed)})
+
+	if err != nil {
+		return fmt.Errorf("opensearch: error indexing %d entries: %w", failed, err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("opensearch: error indexing %d entries: %s", failed, lastItemError)
+	}
+
+	return nil
+}
+
+func (o *Output) addStats(s Stats) {
+	o.statsMutex.Lock()
+	defer o.statsMutex.Unlock()
+
+	o.stats.Indexed += s.Indexed
+	o.stats.Failed += s.Failed
+	o.stats.Retried += s.Retried
+	o.stats.Requests += s.Requests
+}
+
+func (o *Output) buildItem(entry batch.Entry) bulkItem {
+	actionName := "index"
+	if o.config.UseCreate {
+		actionName = "create"
+	}
+
+	return bulkItem{
+		action: []byte(`{"` + actionName + `":{"_index":` +
+			klog.EncodeJSON(IndexName(o.config.Index, entry.Time)) + `}}`),
+		document: []byte(Document(entry.Time, &entry.Data)),
+	}
+}
+
+func buildBody(items []bulkItem) []byte {
+	var buf bytes.Buffer
+	for _, item := range items {
+		buf.Write(item.action)
+		buf.WriteByte('\n')
+		buf.Write(item.document)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+var indexTimeLayout = regexp.MustCompile(`\{([^}]*)\}`)
+
+// IndexName formats the time layouts between braces
+// on the index pattern using the received time in UTC.
+func IndexName(pattern string, t time.Time) string {
+	return indexTimeLayout.ReplaceAllStringFunc(pattern, func(match string) string {
+		return t.UTC().Format(match[1 : len(match)-1])
+	})
+}
+
+// Document builds the JSON document indexed for the received entry,
+// with the `@timestamp`, `level` and `title` fields along with all
+// the values of the Body.
+func Document(t time.Time, data *klog.LogData) string {
+	values := []string{
+		`"@timestamp":"` + t.UTC().Format(time.RFC3339Nano) + `"`,
+		`"level":` + klog.EncodeJSON(data.Level),
+		`"title":` + klog.EncodeJSON(data.Title),
+	}
+
+	for k, v := range data.Body {
+		values = append(values, klog.EncodeJSON(k)+`:`+klog.EncodeJSON(v))
+	}
+	sort.Strings(values[3:])
+
+	return "{" + strings.Join(values, ",") + "}"
+}
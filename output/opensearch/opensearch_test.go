@@ -0,0 +1,179 @@
+package opensearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/retry"
+)
+
+func TestOutput(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+
+	t.Run("should send the entries as bulk requests", func(t *testing.T) {
+		var lines []string
+		var auth string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/_bulk", r.URL.Path)
+			assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+			auth = r.Header.Get("Authorization")
+
+			lines = readLines(t, r)
+			_, _ = fmt.Fprint(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:      server.URL,
+			Index:    "logs-{2006.01.02}",
+			Username: "fake-user",
+			Password: "fake-pass",
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &klog.LogData{
+			Level: "INFO",
+			Title: "fake-title",
+			Body:  klog.Body{"user_id": 42},
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.Close()
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, "Basic ZmFrZS11c2VyOmZha2UtcGFzcw==", auth)
+		assert.Equal(t, []string{
+			`{"index":{"_index":"logs-2024.10.09"}}`,
+			`{"@timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title","user_id":42}`,
+		}, lines)
+		assert.Equal(t, Stats{Indexed: 1, Requests: 1}, output.Stats())
+	})
+
+	t.Run("should retry only the items that failed temporarily", func(t *testing.T) {
+		var requests [][]string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lines := readLines(t, r)
+			requests = append(requests, lines)
+
+			if len(requests) == 1 {
+				_, _ = fmt.Fprint(w, `{"errors":true,"items":[`+
+					`{"create":{"status":201}},`+
+					`{"create":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},`+
+					`{"create":{"status":400,"error":{"type":"mapper_parsing_exception"}}}`+
+					`]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"errors":false,"items":[{"create":{"status":201}}]}`)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:       server.URL,
+			Index:     "logs",
+			UseCreate: true,
+			Retry:     retry.Config{MinBackoff: time.Millisecond},
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		for _, title := range []string{"indexed", "retried", "rejected"} {
+			_ = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: title})
+		}
+
+		err = output.Flush()
+		assert.NotEqual(t, nil, err)
+		assert.True(t, strings.Contains(err.Error(), "mapper_parsing_exception"))
+
+		assert.Equal(t, 2, len(requests))
+		assert.Equal(t, 6, len(requests[0]))
+		assert.Equal(t, []string{
+			`{"create":{"_index":"logs"}}`,
+			`{"@timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"retried"}`,
+		}, requests[1])
+		assert.Equal(t, Stats{Indexed: 2, Failed: 1, Retried: 1, Requests: 2}, output.Stats())
+	})
+
+	t.Run("should retry failed requests", func(t *testing.T) {
+		var attempts int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:   server.URL,
+			Index: "logs",
+			Retry: retry.Config{MinBackoff: time.Millisecond},
+		})
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		_ = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "fake-title"})
+
+		err = output.Flush()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, Stats{Indexed: 1, Requests: 2}, output.Stats())
+	})
+
+	t.Run("should stop retrying when Close takes longer than the CloseTimeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			URL:          server.URL,
+			Index:        "logs",
+			Retry:        retry.Config{MinBackoff: time.Minute},
+			CloseTimeout: 10 * time.Millisecond,
+		})
+		assert.Equal(t, nil, err)
+
+		_ = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "fake-title"})
+
+		start := time.Now()
+		err = output.Close()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, true, time.Since(start) < time.Second)
+	})
+}
+
+func TestIndexName(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+
+	assert.Equal(t, "logs", IndexName("logs", now))
+	assert.Equal(t, "logs-2024.10.09", IndexName("logs-{2006.01.02}", now))
+	assert.Equal(t, "logs-2024-10", IndexName("logs-{2006}-{01}", now))
+}
+
+func readLines(t *testing.T, r *http.Request) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		assert.True(t, json.Valid([]byte(line)))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
@@ -0,0 +1,295 @@
+// Package otlp implements a klog Output that exports the log entries
+// as OpenTelemetry LogRecords using OTLP over HTTP, with either JSON
+// or protobuf payloads.
+//
+// The title is exported as the body of the LogRecord, the Body as its
+// attributes and the klog level is mapped to the severity fields.
+//
+// The trace and span IDs are read from the `trace_id`, `span_id` and
+// `trace_flags` Body keys, which are usually added by a ContextParser
+// that reads the active span from the context.
+package otlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/batch"
+	"github.com/vingarcia/klog/internal/retry"
+)
+
+// LogsPath is the path of the OTLP/HTTP logs endpoint.
+const LogsPath = "/v1/logs"
+
+// Protocol describes the payload format of the export requests.
+type Protocol int
+
+const (
+	// HTTPProtobuf sends binary protobuf payloads.
+	HTTPProtobuf Protocol = iota
+
+	// HTTPJSON sends JSON payloads.
+	HTTPJSON
+)
+
+// Config contains the configurations for the OTLP Output.
+type Config struct {
+	// Endpoint is the address of the collector, e.g. http://localhost:4318,
+	// the LogsPath is appended to it.
+	Endpoint string
+
+	// Protocol defaults to HTTPProtobuf.
+	Protocol Protocol
+
+	// Headers are added to all the requests.
+	Headers map[string]string
+
+	// ServiceName and ServiceVersion are exported as the
+	// `service.name` and `service.version` resource attributes.
+	ServiceName    string
+	ServiceVersion string
+
+	// ResourceAttributes are exported as attributes of the resource.
+	ResourceAttributes map[string]interface{}
+
+	// ScopeName defaults to "github.com/vingarcia/klog".
+	ScopeName string
+
+	// TraceIDKey, SpanIDKey and TraceFlagsKey are the Body keys
+	// containing the trace context, they default to "trace_id",
+	// "span_id" and "trace_flags".
+	TraceIDKey    string
+	SpanIDKey     string
+	TraceFlagsKey string
+
+	// BatchSize is the number of entries that triggers
+	// sending the batch, defaults to 512.
+	BatchSize int
+
+	// BatchWait is the maximum time an entry waits on
+	// the batch before being sent, defaults to 1s.
+	BatchWait time.Duration
+
+	// CloseTimeout is the maximum time Close waits for the
+	// pending batches to be sent, defaults to 10s.
+	CloseTimeout time.Duration
+
+	// Retry configures the retries of failed requests,
+	// only 429 and 5xx responses and network errors are retried.
+	Retry retry.Config
+
+	// HTTPClient defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that exports batches of entries
+// to an OpenTelemetry collector.
+type Output struct {
+	config   Config
+	url      string
+	headers  map[string]string
+	resource []keyValue
+	batcher  *batch.Batcher
+}
+
+// New returns an Output that exports the entries to the collector.
+func New(config Config) (*Output, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("otlp: missing Endpoint")
+	}
+	if config.ScopeName == "" {
+		config.ScopeName = "github.com/vingarcia/klog"
+	}
+	if config.TraceIDKey == "" {
+		config.TraceIDKey = "trace_id"
+	}
+	if config.SpanIDKey == "" {
+		config.SpanIDKey = "span_id"
+	}
+	if config.TraceFlagsKey == "" {
+		config.TraceFlagsKey = "trace_flags"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	headers := map[string]string{}
+	for k, v := range config.Headers {
+		headers[k] = v
+	}
+	if config.Protocol == HTTPJSON {
+		headers["Content-Type"] = "application/json"
+	} else {
+		headers["Content-Type"] = "application/x-protobuf"
+	}
+
+	resourceAttrs := klog.Body{}
+	klog.MergeMaps(&resourceAttrs, config.ResourceAttributes)
+	if config.ServiceName != "" {
+		resourceAttrs["service.name"] = config.ServiceName
+	}
+	if config.ServiceVersion != "" {
+		resourceAttrs["service.version"] = config.ServiceVersion
+	}
+
+	o := &Output{
+		config:   config,
+		url:      strings.TrimSuffix(config.Endpoint, "/") + LogsPath,
+		headers:  headers,
+		resource: attributes(resourceAttrs),
+	}
+	o.batcher = batch.New(batch.Config{
+		Name:         "otlp",
+		MaxEntries:   config.BatchSize,
+		MaxWait:      config.BatchWait,
+		CloseTimeout: config.CloseTimeout,
+	}, o.export)
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	return o.batcher.Add(t, data, 0)
+}
+
+// Flush sends the current batch.
+func (o *Output) Flush() error {
+	return o.batcher.Flush()
+}
+
+// Close sends the current batch and stops the Output.
+func (o *Output) Close() error {
+	return o.batcher.Close()
+}
+
+// SeverityNumber maps a klog level to its OpenTelemetry severity number.
+func SeverityNumber(level string) int {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return 5
+	case "INFO":
+		return 9
+	case "WARN":
+		return 13
+	case "ERROR":
+		return 17
+	case "FATAL":
+		return 21
+	default:
+		return 0
+	}
+}
+
+type logRecord struct {
+	time           time.Time
+	observedTime   time.Time
+	severityNumber int
+	severityText   string
+	body           string
+	attributes     []keyValue
+	traceID        []byte
+	spanID         []byte
+	flags          uint32
+}
+
+func (o *Output) export(entries []batch.Entry) error {
+	now := time.Now()
+
+	records := make([]logRecord, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, o.buildRecord(entry, now))
+	}
+
+	var body []byte
+	if o.config.Protocol == HTTPJSON {
+		var err error
+		body, err = json.Marshal(o.jsonRequest(records))
+		if err != nil {
+			return fmt.Errorf("otlp: error encoding request: %w", err)
+		}
+	} else {
+		body = o.protobufRequest(records)
+	}
+
+	ctx := o.batcher.Context()
+	err := retry.Do(ctx, o.config.Retry, func() error {
+		_, err := retry.Post(ctx, o.config.HTTPClient, o.url, o.headers, body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("otlp: error exporting %d entries: %w", len(entries), err)
+	}
+
+	return nil
+}
+
+func (o *Output) buildRecord(entry batch.Entry, observedTime time.Time) logRecord {
+	body := entry.Data.Body
+
+	record := logRecord{
+		time:           entry.Time,
+		observedTime:   observedTime,
+		severityNumber: SeverityNumber(entry.Data.Level),
+		severityText:   entry.Data.Level,
+		body:           entry.Data.Title,
+	}
+
+	if id, ok := decodeID(body[o.config.TraceIDKey], 16); ok {
+		record.traceID = id
+		delete(body, o.config.TraceIDKey)
+	}
+	if id, ok := decodeID(body[o.config.SpanIDKey], 8); ok {
+		record.spanID = id
+		delete(body, o.config.SpanIDKey)
+	}
+	if flags, ok := decodeFlags(body[o.config.TraceFlagsKey]); ok {
+		record.flags = flags
+		delete(body, o.config.TraceFlagsKey)
+	}
+
+	record.attributes = attributes(body)
+	return record
+}
+
+// decodeID decodes hex encoded trace and span IDs with the expected size.
+func decodeID(value interface{}, size int) ([]byte, bool) {
+	s, ok := value.(string)
+	if !ok || len(s) != 2*size {
+		return nil, false
+	}
+
+	id, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	return id, true
+}
+
+// decodeFlags accepts the trace flags either as
+// numbers or as hex strings, e.g. "01".
+func decodeFlags(value interface{}) (uint32, bool) {
+	switch v := value.(type) {
+	case int:
+		return uint32(v), v >= 0 && v <= 0xff
+	case uint8:
+		return uint32(v), true
+	case string:
+		flags, err := strconv.ParseUint(v, 16, 8)
+		return uint32(flags), err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,231 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/protowire"
+	"github.com/vingarcia/klog/internal/retry"
+)
+
+func TestOutput(t *testing.T) {
+	now := time.Unix(1728464400, 0)
+	data := klog.LogData{
+		Level: "WARN",
+		Title: "fake-title",
+		Body: klog.Body{
+			"user_id":     42,
+			"ratio":       0.5,
+			"ok":          false,
+			"tags":        []string{"a"},
+			"nested":      map[string]interface{}{"key": "value"},
+			"trace_id":    "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":     "00f067aa0ba902b7",
+			"trace_flags": "01",
+		},
+	}
+
+	t.Run("should export JSON payloads", func(t *testing.T) {
+		var payload map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, LogsPath, r.URL.Path)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "fake-key", r.Header.Get("X-Api-Key"))
+
+			err := json.NewDecoder(r.Body).Decode(&payload)
+			assert.Equal(t, nil, err)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			Endpoint:       server.URL,
+			Protocol:       HTTPJSON,
+			Headers:        map[string]string{"X-Api-Key": "fake-key"},
+			ServiceName:    "fake-service",
+			ServiceVersion: "1.0.0",
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &data)
+		assert.Equal(t, nil, err)
+		err = output.Close()
+		assert.Equal(t, nil, err)
+
+		resourceLogs := payload["resourceLogs"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{
+			"attributes": []interface{}{
+				map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "fake-service"}},
+				map[string]interface{}{"key": "service.version", "value": map[string]interface{}{"stringValue": "1.0.0"}},
+			},
+		}, resourceLogs["resource"])
+
+		scopeLogs := resourceLogs["scopeLogs"].([]interface{})[0].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"name": "github.com/vingarcia/klog"}, scopeLogs["scope"])
+
+		record := scopeLogs["logRecords"].([]interface{})[0].(map[string]interface{})
+		delete(record, "observedTimeUnixNano")
+		assert.Equal(t, map[string]interface{}{
+			"timeUnixNano":   "1728464400000000000",
+			"severityNumber": float64(13),
+			"severityText":   "WARN",
+			"body":           map[string]interface{}{"stringValue": "fake-title"},
+			"traceId":        "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId":         "00f067aa0ba902b7",
+			"flags":          float64(1),
+			"attributes": []interface{}{
+				map[string]interface{}{"key": "nested", "value": map[string]interface{}{
+					"kvlistValue": map[string]interface{}{"values": []interface{}{
+						map[string]interface{}{"key": "key", "value": map[string]interface{}{"stringValue": "value"}},
+					}},
+				}},
+				map[string]interface{}{"key": "ok", "value": map[string]interface{}{"boolValue": false}},
+				map[string]interface{}{"key": "ratio", "value": map[string]interface{}{"doubleValue": 0.5}},
+				map[string]interface{}{"key": "tags", "value": map[string]interface{}{
+					"arrayValue": map[string]interface{}{"values": []interface{}{
+						map[string]interface{}{"stringValue": "a"},
+					}},
+				}},
+				map[string]interface{}{"key": "user_id", "value": map[string]interface{}{"intValue": "42"}},
+			},
+		}, record)
+	})
+
+	t.Run("should export protobuf payloads", func(t *testing.T) {
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+			var err error
+			body, err = io.ReadAll(r.Body)
+			assert.Equal(t, nil, err)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			Endpoint:    server.URL,
+			ServiceName: "fake-service",
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &data)
+		assert.Equal(t, nil, err)
+		err = output.Close()
+		assert.Equal(t, nil, err)
+
+		resourceLogs := decodeField(t, body, 1)
+		resource := decodeField(t, resourceLogs, 1)
+		assert.Equal(t, "service.name", string(decodeField(t, decodeField(t, resource, 1), 1)))
+
+		scopeLogs := decodeField(t, resourceLogs, 2)
+		assert.Equal(t, "github.com/vingarcia/klog", string(decodeField(t, decodeField(t, scopeLogs, 1), 1)))
+
+		fields, err := protowire.Decode(decodeField(t, scopeLogs, 2))
+		assert.Equal(t, nil, err)
+
+		var attributeKeys []string
+		values := map[int]interface{}{}
+		for _, f := range fields {
+			if f.Num == 6 {
+				attributeKeys = append(attributeKeys, string(decodeField(t, f.Value.([]byte), 1)))
+				continue
+			}
+			values[f.Num] = f.Value
+		}
+
+		assert.Equal(t, []string{"nested", "ok", "ratio", "tags", "user_id"}, attributeKeys)
+		assert.Equal(t, uint64(1728464400000000000), values[1])
+		assert.Equal(t, uint64(13), values[2])
+		assert.Equal(t, []byte("WARN"), values[3])
+		assert.Equal(t, "fake-title", string(decodeField(t, values[5].([]byte), 1)))
+		assert.Equal(t, uint64(1), values[8])
+		assert.Equal(t, []byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}, values[9])
+		assert.Equal(t, []byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}, values[10])
+	})
+
+	t.Run("should stop retrying when Close takes longer than the CloseTimeout", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		output, err := New(Config{
+			Endpoint:     server.URL,
+			Retry:        retry.Config{MinBackoff: time.Minute},
+			CloseTimeout: 10 * time.Millisecond,
+		})
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &data)
+		assert.Equal(t, nil, err)
+
+		start := time.Now()
+		err = output.Close()
+		assert.NotEqual(t, nil, err)
+		assert.Equal(t, true, time.Since(start) < time.Second)
+	})
+}
+
+func TestToAnyValue(t *testing.T) {
+	type user struct {
+		Name string `json:"name"`
+	}
+
+	tests := []struct {
+		desc          string
+		value         interface{}
+		expectedValue map[string]interface{}
+	}{
+		{
+			desc:          "should convert nil to an empty value",
+			value:         nil,
+			expectedValue: map[string]interface{}{},
+		},
+		{
+			desc:          "should convert unsigned integers",
+			value:         uint8(7),
+			expectedValue: map[string]interface{}{"intValue": "7"},
+		},
+		{
+			desc:          "should convert durations to integers",
+			value:         time.Second,
+			expectedValue: map[string]interface{}{"intValue": "1000000000"},
+		},
+		{
+			desc:  "should convert structs using their JSON representation",
+			value: user{Name: "fake-name"},
+			expectedValue: map[string]interface{}{"kvlistValue": map[string]interface{}{"values": []interface{}{
+				map[string]interface{}{"key": "name", "value": map[string]interface{}{"stringValue": "fake-name"}},
+			}}},
+		},
+		{
+			desc:          "should convert bytes to bytes values",
+			value:         []byte("abc"),
+			expectedValue: map[string]interface{}{"bytesValue": []byte("abc")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.expectedValue, toAnyValue(test.value, 0).jsonValue())
+		})
+	}
+}
+
+func decodeField(t *testing.T, msg []byte, num int) []byte {
+	fields, err := protowire.Decode(msg)
+	if err != nil {
+		t.Fatalf("unable to decode message: %s", err)
+	}
+	for _, f := range fields {
+		if f.Num == num {
+			return f.Value.([]byte)
+		}
+	}
+	t.Fatalf("field %d not found", num)
+	return nil
+}
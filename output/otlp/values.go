@@ -0,0 +1,324 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/protowire"
+)
+
+// maxDepth limits how deep nested values are converted,
+// deeper values are converted to their JSON representation.
+const maxDepth = 8
+
+type valueKind int
+
+const (
+	emptyValue valueKind = iota
+	stringValue
+	boolValue
+	intValue
+	doubleValue
+	arrayValue
+	kvlistValue
+	bytesValue
+)
+
+// anyValue is the intermediate representation of an OTLP
+// AnyValue shared by the JSON and protobuf encoders.
+type anyValue struct {
+	kind   valueKind
+	str    string
+	boolV  bool
+	intV   int64
+	double float64
+	bytes  []byte
+	array  []anyValue
+	kvlist []keyValue
+}
+
+type keyValue struct {
+	key   string
+	value anyValue
+}
+
+// attributes converts the Body into OTLP attributes sorted by key.
+func attributes(body klog.Body) []keyValue {
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]keyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, keyValue{
+			key:   k,
+			value: toAnyValue(body[k], 0),
+		})
+	}
+	return attrs
+}
+
+func toAnyValue(value interface{}, depth int) anyValue {
+	switch v := value.(type) {
+	case nil:
+		return anyValue{kind: emptyValue}
+	case string:
+		return anyValue{kind: stringValue, str: v}
+	case bool:
+		return anyValue{kind: boolValue, boolV: v}
+	case []byte:
+		return anyValue{kind: bytesValue, bytes: v}
+	case error:
+		return anyValue{kind: stringValue, str: v.Error()}
+	case json.Marshaler, fmt.Stringer:
+		return fromJSON(value, depth)
+	}
+
+	if depth >= maxDepth {
+		return anyValue{kind: stringValue, str: klog.EncodeJSON(value)}
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return anyValue{kind: emptyValue}
+		}
+		return toAnyValue(rv.Elem().Interface(), depth+1)
+	case reflect.String:
+		return anyValue{kind: stringValue, str: rv.String()}
+	case reflect.Bool:
+		return anyValue{kind: boolValue, boolV: rv.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return anyValue{kind: intValue, intV: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return anyValue{kind: stringValue, str: strconv.FormatUint(u, 10)}
+		}
+		return anyValue{kind: intValue, intV: int64(u)}
+	case reflect.Float32, reflect.Float64:
+		return anyValue{kind: doubleValue, double: rv.Float()}
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return anyValue{kind: emptyValue}
+		}
+		array := make([]anyValue, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			array = append(array, toAnyValue(rv.Index(i).Interface(), depth+1))
+		}
+		return anyValue{kind: arrayValue, array: array}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fromJSON(value, depth)
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+
+		kvlist := make([]keyValue, 0, len(keys))
+		for _, k := range keys {
+			kvlist = append(kvlist, keyValue{
+				key:   k,
+				value: toAnyValue(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface(), depth+1),
+			})
+		}
+		return anyValue{kind: kvlistValue, kvlist: kvlist}
+	default:
+		return fromJSON(value, depth)
+	}
+}
+
+// fromJSON converts the value using its JSON representation,
+// so structs are converted to kvlists honoring their JSON tags.
+func fromJSON(value interface{}, depth int) anyValue {
+	rawJSON, err := json.Marshal(value)
+	if err != nil {
+		return anyValue{kind: stringValue, str: fmt.Sprintf("%+v", value)}
+	}
+
+	var decoded interface{}
+	err = json.Unmarshal(rawJSON, &decoded)
+	if err != nil {
+		return anyValue{kind: stringValue, str: string(rawJSON)}
+	}
+
+	if f, ok := decoded.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return anyValue{kind: intValue, intV: int64(f)}
+	}
+	return toAnyValue(decoded, depth+1)
+}
+
+// jsonValue returns the OTLP/JSON representation of the value,
+// which encodes 64 bit integers as strings, bytes as base64
+// and non finite doubles as strings.
+func (v anyValue) jsonValue() map[string]interface{} {
+	switch v.kind {
+	case stringValue:
+		return map[string]interface{}{"stringValue": v.str}
+	case boolValue:
+		return map[string]interface{}{"boolValue": v.boolV}
+	case intValue:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v.intV, 10)}
+	case doubleValue:
+		switch {
+		case math.IsNaN(v.double):
+			return map[string]interface{}{"doubleValue": "NaN"}
+		case math.IsInf(v.double, 1):
+			return map[string]interface{}{"doubleValue": "Infinity"}
+		case math.IsInf(v.double, -1):
+			return map[string]interface{}{"doubleValue": "-Infinity"}
+		}
+		return map[string]interface{}{"doubleValue": v.double}
+	case bytesValue:
+		return map[string]interface{}{"bytesValue": v.bytes}
+	case arrayValue:
+		values := make([]interface{}, 0, len(v.array))
+		for _, item := range v.array {
+			values = append(values, item.jsonValue())
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case kvlistValue:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": jsonKeyValues(v.kvlist)}}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func jsonKeyValues(kvs []keyValue) []interface{} {
+	values := make([]interface{}, 0, len(kvs))
+	for _, kv := range kvs {
+		values = append(values, map[string]interface{}{
+			"key":   kv.key,
+			"value": kv.value.jsonValue(),
+		})
+	}
+	return values
+}
+
+func (o *Output) jsonRequest(records []logRecord) interface{} {
+	logRecords := make([]interface{}, 0, len(records))
+	for _, r := range records {
+		record := map[string]interface{}{
+			"timeUnixNano":         strconv.FormatInt(r.time.UnixNano(), 10),
+			"observedTimeUnixNano": strconv.FormatInt(r.observedTime.UnixNano(), 10),
+			"severityNumber":       r.severityNumber,
+			"severityText":         r.severityText,
+			"body":                 anyValue{kind: stringValue, str: r.body}.jsonValue(),
+			"attributes":           jsonKeyValues(r.attributes),
+		}
+		if r.traceID != nil {
+			record["traceId"] = hex.EncodeToString(r.traceID)
+		}
+		if r.spanID != nil {
+			record["spanId"] = hex.EncodeToString(r.spanID)
+		}
+		if r.flags != 0 {
+			record["flags"] = r.flags
+		}
+		logRecords = append(logRecords, record)
+	}
+
+	return map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": jsonKeyValues(o.resource),
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": o.config.ScopeName,
+						},
+						"logRecords": logRecords,
+					},
+				},
+			},
+		},
+	}
+}
+
+// protobuf encodes the value as an AnyValue message, the fields
+// of the oneof are always encoded even when they are empty.
+func (v anyValue) protobuf() []byte {
+	var b []byte
+	switch v.kind {
+	case stringValue:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(len(v.str)))
+		b = append(b, v.str...)
+	case boolValue:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		if v.boolV {
+			b = protowire.AppendVarint(b, 1)
+		} else {
+			b = protowire.AppendVarint(b, 0)
+		}
+	case intValue:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.intV))
+	case doubleValue:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		var fixed [8]byte
+		binary.LittleEndian.PutUint64(fixed[:], math.Float64bits(v.double))
+		b = append(b, fixed[:]...)
+	case arrayValue:
+		var array []byte
+		for _, item := range v.array {
+			array = protowire.AppendMessageField(array, 1, item.protobuf())
+		}
+		b = protowire.AppendMessageField(b, 5, array)
+	case kvlistValue:
+		b = protowire.AppendMessageField(b, 6, protobufKeyValues(nil, 1, v.kvlist))
+	case bytesValue:
+		b = protowire.AppendMessageField(b, 7, v.bytes)
+	}
+	return b
+}
+
+func protobufKeyValues(b []byte, num int, kvs []keyValue) []byte {
+	for _, kv := range kvs {
+		var msg []byte
+		msg = protowire.AppendStringField(msg, 1, kv.key)
+		msg = protowire.AppendMessageField(msg, 2, kv.value.protobuf())
+		b = protowire.AppendMessageField(b, num, msg)
+	}
+	return b
+}
+
+// protobufRequest encodes the records as an ExportLogsServiceRequest.
+func (o *Output) protobufRequest(records []logRecord) []byte {
+	var scopeLogs []byte
+	scopeLogs = protowire.AppendMessageField(scopeLogs, 1, protowire.AppendStringField(nil, 1, o.config.ScopeName))
+	for _, r := range records {
+		var record []byte
+		record = protowire.AppendFixed64Field(record, 1, uint64(r.time.UnixNano()))
+		record = protowire.AppendVarintField(record, 2, uint64(r.severityNumber))
+		record = protowire.AppendStringField(record, 3, r.severityText)
+		record = protowire.AppendMessageField(record, 5, anyValue{kind: stringValue, str: r.body}.protobuf())
+		record = protobufKeyValues(record, 6, r.attributes)
+		record = protowire.AppendFixed32Field(record, 8, r.flags)
+		record = protowire.AppendBytesField(record, 9, r.traceID)
+		record = protowire.AppendBytesField(record, 10, r.spanID)
+		record = protowire.AppendFixed64Field(record, 11, uint64(r.observedTime.UnixNano()))
+
+		scopeLogs = protowire.AppendMessageField(scopeLogs, 2, record)
+	}
+
+	var resourceLogs []byte
+	resourceLogs = protowire.AppendMessageField(resourceLogs, 1, protobufKeyValues(nil, 1, o.resource))
+	resourceLogs = protowire.AppendMessageField(resourceLogs, 2, scopeLogs)
+
+	return protowire.AppendMessageField(nil, 1, resourceLogs)
+}
@@ -0,0 +1,502 @@
+// Package rotatefile implements a klog Output that writes the
+// log entries to a file that is rotated by size and/or age.
+//
+// Rotated files are named after the original file with the
+// rotation time appended to it, e.g. `app-2024-10-09T09-00-00.000.log`,
+// followed by a sequence number if there is already a file with that
+// name, e.g. `app-2024-10-09T09-00-00.000.1.log`, and can optionally
+// be compressed with gzip.
+package rotatefile
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// Config contains the configurations for the rotating file Writer.
+type Config struct {
+	// Filename is the path of the file the entries are written to,
+	// it is required.
+	Filename string
+
+	// MaxSize is the maximum size in bytes the file is allowed
+	// to reach before being rotated, zero means no limit.
+	MaxSize int64
+
+	// RotateEvery rotates the file every time this interval elapses,
+	// zero disables time based rotation.
+	RotateEvery time.Duration
+
+	// Compress enables gzip compression of the rotated files.
+	Compress bool
+
+	// MaxFiles is the maximum number of rotated files to keep,
+	// zero means no limit.
+	MaxFiles int
+
+	// MaxAge is the maximum age of the rotated files to keep,
+	// zero means no limit.
+	MaxAge time.Duration
+
+	// ReopenOnSIGHUP makes the Writer reopen the file when
+	// the process receives a SIGHUP, which is what logrotate
+	// expects from programs when using its `create` mode.
+	//
+	// This option has no effect on Windows.
+	ReopenOnSIGHUP bool
+}
+
+var _ klog.Output = &Writer{}
+var _ io.WriteCloser = &Writer{}
+
+// Writer is an io.Writer and a klog.Output that
+// writes to a file that is rotated automatically.
+type Writer struct {
+	config  Config
+	timeNow func() time.Time
+	rename  func(oldpath, newpath string) error
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool
+
+	// backups holds the rotated files waiting to be compressed
+	// and pruned by the worker goroutine, which is woken up by
+	// a send on wakeWorker.
+	backups      []string
+	wakeWorker   chan struct{}
+	workerDone   chan struct{}
+	compressions sync.WaitGroup
+
+	stopSignals func()
+	closeOnce   sync.Once
+}
+
+// New opens or creates the configured file for appending
+// and returns a Writer for it.
+func New(config Config) (*Writer, error) {
+	if config.Filename == "" {
+		return nil, errors.New("rotatefile: missing Filename")
+	}
+
+	w := &Writer{
+		config:     config,
+		timeNow:    time.Now,
+		rename:     os.Rename,
+		wakeWorker: make(chan struct{}, 1),
+		workerDone: make(chan struct{}),
+	}
+
+	err := w.open()
+	if err != nil {
+		return nil, err
+	}
+
+	go w.runWorker()
+
+	if config.ReopenOnSIGHUP {
+		w.stopSignals = notifyReopen(w)
+	}
+
+	return w, nil
+}
+
+// WriteLog implements the klog.Output interface writing the entry
+// as a JSON line in the same format as klog's default output.
+func (w *Writer) WriteLog(t time.Time, data *klog.LogData) error {
+	_, err := w.Write([]byte(klog.FormatJSON(t, data) + "\n"))
+	return err
+}
+
+// Write implements the io.Writer interface, rotating
+// the file before writing if necessary.
+//
+// If the rotation fails the content is still written to the
+// original file when possible, and the rotation error is returned.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.ensureOpen()
+	if err != nil {
+		return 0, err
+	}
+
+	var rotateErr error
+	if w.shouldRotate(int64(len(p))) {
+		rotateErr = w.rotate()
+		if w.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Flush commits the content of the file to stable storage.
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Rotate forces the rotation of the current file.
+func (w *Writer) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.ensureOpen()
+	if err != nil {
+		return err
+	}
+	return w.rotate()
+}
+
+// Reopen closes and reopens the file on the configured path,
+// which is necessary after external tools such as logrotate
+// move the file away.
+func (w *Writer) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return klog.ErrOutputClosed
+	}
+
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			return fmt.Errorf("rotatefile: error closing file: %w", err)
+		}
+	}
+	return w.open()
+}
+
+// Close closes the file and waits for any pending compressions.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+
+	w.closeOnce.Do(func() {
+		if w.stopSignals != nil {
+			w.stopSignals()
+		}
+		close(w.wakeWorker)
+	})
+
+	<-w.workerDone
+	return err
+}
+
+// ensureOpen opens the file again if a previous rotation or
+// Reopen failed to do so, which means that a failure to open
+// the file only lasts until the problem is solved.
+func (w *Writer) ensureOpen() error {
+	if w.closed {
+		return klog.ErrOutputClosed
+	}
+	if w.file != nil {
+		return nil
+	}
+	return w.open()
+}
+
+func (w *Writer) open() error {
+	err := os.MkdirAll(filepath.Dir(w.config.Filename), 0755)
+	if err != nil {
+		return fmt.Errorf("rotatefile: error creating log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(w.config.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("rotatefile: error opening log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("rotatefile: error reading log file info: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.timeNow()
+	return nil
+}
+
+func (w *Writer) shouldRotate(writeLen int64) bool {
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+writeLen > w.config.MaxSize {
+		return true
+	}
+
+	if w.config.RotateEvery > 0 && !w.timeNow().Before(w.openedAt.Add(w.config.RotateEvery)) {
+		return true
+	}
+
+	return false
+}
+
+// rotate moves the current file to a backup name and opens a new one,
+// if it fails w.file is either the original file opened again or nil,
+// in which case the next Write tries to open it.
+func (w *Writer) rotate() error {
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("rotatefile: error closing file for rotation: %w", err)
+	}
+
+	backupName := w.backupName(w.timeNow())
+	err = w.rename(w.config.Filename, backupName)
+	renamed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		// Keep writing to the original file, the rotation
+		// is tried again on the next Write:
+		_ = w.open()
+		return fmt.Errorf("rotatefile: error renaming file for rotation: %w", err)
+	}
+
+	err = w.open()
+	if err != nil {
+		return err
+	}
+
+	if renamed {
+		w.backups = append(w.backups, backupName)
+		w.compressions.Add(1)
+		select {
+		case w.wakeWorker <- struct{}{}:
+		default:
+			// The worker was already woken up.
+		}
+	}
+
+	return nil
+}
+
+// runWorker compresses the rotated files and removes the old ones on
+// a single goroutine, so that the removal never races with compressing
+// another backup, until wakeWorker is closed.
+func (w *Writer) runWorker() {
+	defer close(w.workerDone)
+
+	for range w.wakeWorker {
+		w.processBackups()
+	}
+
+	// Process the backups rotated right before Close:
+	w.processBackups()
+}
+
+func (w *Writer) processBackups() {
+	w.mutex.Lock()
+	backups := w.backups
+	w.backups = nil
+	w.mutex.Unlock()
+
+	for _, backupName := range backups {
+		if w.config.Compress {
+			err := compressFile(backupName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "rotatefile: error compressing '%s': %s\n", backupName, err)
+			}
+		}
+
+		err := w.removeOldBackups()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rotatefile: error removing old log files: %s\n", err)
+		}
+
+		w.compressions.Done()
+	}
+}
+
+// backupName returns a name for the rotated file that is not used by
+// any other backup, compressed or not, so that rotating more than once
+// on the same millisecond never overwrites the previous backup.
+func (w *Writer) backupName(t time.Time) string {
+	dir, prefix, ext := w.nameParts()
+	timestamp := t.UTC().Format(backupTimeFormat)
+
+	name := filepath.Join(dir, prefix+timestamp+ext)
+	for seq := 1; fileExists(name) || fileExists(name+".gz"); seq++ {
+		name = filepath.Join(dir, prefix+timestamp+"."+strconv.Itoa(seq)+ext)
+	}
+	return name
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (w *Writer) nameParts() (dir string, prefix string, ext string) {
+	dir = filepath.Dir(w.config.Filename)
+	base := filepath.Base(w.config.Filename)
+	ext = filepath.Ext(base)
+	prefix = strings.TrimSuffix(base, ext) + "-"
+	return dir, prefix, ext
+}
+
+type backup struct {
+	path string
+	time time.Time
+	seq  int
+}
+
+// listBackups returns the rotated files sorted from the newest to the oldest.
+func (w *Writer) listBackups() ([]backup, error) {
+	dir, prefix, ext := w.nameParts()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		timestamp := strings.TrimPrefix(name, prefix)
+		timestamp = strings.TrimSuffix(timestamp, ".gz")
+		if !strings.HasSuffix(timestamp, ext) {
+			continue
+		}
+		timestamp = strings.TrimSuffix(timestamp, ext)
+
+		t, seq, ok := parseBackupTimestamp(timestamp)
+		if !ok {
+			continue
+		}
+
+		backups = append(backups, backup{
+			path: filepath.Join(dir, name),
+			time: t,
+			seq:  seq,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.After(backups[j].time)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+
+	return backups, nil
+}
+
+// parseBackupTimestamp parses the rotation time
+// and the optional sequence number of a backup name.
+func parseBackupTimestamp(timestamp string) (t time.Time, seq int, ok bool) {
+	if len(timestamp) < len(backupTimeFormat) {
+		return time.Time{}, 0, false
+	}
+
+	t, err := time.Parse(backupTimeFormat, timestamp[:len(backupTimeFormat)])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	rest := timestamp[len(backupTimeFormat):]
+	if rest == "" {
+		return t, 0, true
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return time.Time{}, 0, false
+	}
+	seq, err = strconv.Atoi(rest[1:])
+	if err != nil || seq <= 0 {
+		return time.Time{}, 0, false
+	}
+	return t, seq, true
+}
+
+func (w *Writer) removeOldBackups() error {
+	if w.config.MaxFiles <= 0 && w.config.MaxAge <= 0 {
+		return nil
+	}
+
+	backups, err := w.listBackups()
+	if err != nil {
+		return err
+	}
+
+	cutoff := w.timeNow().Add(-w.config.MaxAge)
+	for i, b := range backups {
+		tooMany := w.config.MaxFiles > 0 && i >= w.config.MaxFiles
+		tooOld := w.config.MaxAge > 0 && b.time.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		err := os.Remove(b.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,270 @@
+package rotatefile
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+func TestWriter(t *testing.T) {
+	t.Run("should write entries as JSON lines", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newTestWriter(t, Config{Filename: filepath.Join(dir, "app.log")}, nil)
+
+		err := w.WriteLog(parseTime(t, "2024-10-09T09:00:00Z"), &klog.LogData{
+			Level: "INFO",
+			Title: "fake-title",
+			Body:  klog.Body{"key": "value"},
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t,
+			`{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title","key":"value"}`+"\n",
+			readFile(t, filepath.Join(dir, "app.log")),
+		)
+	})
+
+	t.Run("should rotate the file when it reaches the max size", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename: filepath.Join(dir, "app.log"),
+			MaxSize:  10,
+		}, &now)
+
+		_, _ = w.Write([]byte("12345\n"))
+		_, _ = w.Write([]byte("67890\n"))
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{"app-2024-10-09T09-00-00.000.log", "app.log"}, listDir(t, dir))
+		assert.Equal(t, "12345\n", readFile(t, filepath.Join(dir, "app-2024-10-09T09-00-00.000.log")))
+		assert.Equal(t, "67890\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("should rotate the file when the rotation interval elapses", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename:    filepath.Join(dir, "app.log"),
+			RotateEvery: time.Hour,
+		}, &now)
+
+		_, _ = w.Write([]byte("first\n"))
+		now = now.Add(30 * time.Minute)
+		_, _ = w.Write([]byte("second\n"))
+		now = now.Add(30 * time.Minute)
+		_, _ = w.Write([]byte("third\n"))
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{"app-2024-10-09T10-00-00.000.log", "app.log"}, listDir(t, dir))
+		assert.Equal(t, "first\nsecond\n", readFile(t, filepath.Join(dir, "app-2024-10-09T10-00-00.000.log")))
+		assert.Equal(t, "third\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("should compress the rotated files", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename: filepath.Join(dir, "app.log"),
+			Compress: true,
+		}, &now)
+
+		_, _ = w.Write([]byte("compressed\n"))
+		assert.Equal(t, nil, w.Rotate())
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{"app-2024-10-09T09-00-00.000.log.gz", "app.log"}, listDir(t, dir))
+
+		f, err := os.Open(filepath.Join(dir, "app-2024-10-09T09-00-00.000.log.gz"))
+		assert.Equal(t, nil, err)
+		defer func() { _ = f.Close() }()
+		gz, err := gzip.NewReader(f)
+		assert.Equal(t, nil, err)
+		content, err := io.ReadAll(gz)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "compressed\n", string(content))
+	})
+
+	t.Run("should keep at most MaxFiles rotated files", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename: filepath.Join(dir, "app.log"),
+			MaxFiles: 2,
+		}, &now)
+
+		for i := 0; i < 4; i++ {
+			_, _ = w.Write([]byte("line\n"))
+			assert.Equal(t, nil, w.Rotate())
+			w.compressions.Wait()
+			now = now.Add(time.Minute)
+		}
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{
+			"app-2024-10-09T09-02-00.000.log",
+			"app-2024-10-09T09-03-00.000.log",
+			"app.log",
+		}, listDir(t, dir))
+	})
+
+	t.Run("should not overwrite backups rotated on the same millisecond", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename: filepath.Join(dir, "app.log"),
+			MaxFiles: 2,
+		}, &now)
+
+		for _, line := range []string{"first\n", "second\n", "third\n"} {
+			_, _ = w.Write([]byte(line))
+			assert.Equal(t, nil, w.Rotate())
+			w.compressions.Wait()
+		}
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{
+			"app-2024-10-09T09-00-00.000.1.log",
+			"app-2024-10-09T09-00-00.000.2.log",
+			"app.log",
+		}, listDir(t, dir))
+		assert.Equal(t, "second\n", readFile(t, filepath.Join(dir, "app-2024-10-09T09-00-00.000.1.log")))
+		assert.Equal(t, "third\n", readFile(t, filepath.Join(dir, "app-2024-10-09T09-00-00.000.2.log")))
+	})
+
+	t.Run("should remove rotated files older than MaxAge", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{
+			Filename: filepath.Join(dir, "app.log"),
+			MaxAge:   48 * time.Hour,
+		}, &now)
+
+		for i := 0; i < 4; i++ {
+			_, _ = w.Write([]byte("line\n"))
+			assert.Equal(t, nil, w.Rotate())
+			w.compressions.Wait()
+			now = now.Add(24 * time.Hour)
+		}
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{
+			"app-2024-10-10T09-00-00.000.log",
+			"app-2024-10-11T09-00-00.000.log",
+			"app-2024-10-12T09-00-00.000.log",
+			"app.log",
+		}, listDir(t, dir))
+	})
+
+	t.Run("should keep writing to the original file when the rotation fails", func(t *testing.T) {
+		dir := t.TempDir()
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		w := newTestWriter(t, Config{Filename: filepath.Join(dir, "app.log")}, &now)
+		w.rename = func(oldpath, newpath string) error {
+			return errors.New("fake-rename-error")
+		}
+
+		_, _ = w.Write([]byte("before\n"))
+		err := w.Rotate()
+		assert.NotEqual(t, nil, err)
+
+		_, err = w.Write([]byte("after\n"))
+		assert.Equal(t, nil, err)
+
+		w.rename = os.Rename
+		assert.Equal(t, nil, w.Rotate())
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, []string{"app-2024-10-09T09-00-00.000.log", "app.log"}, listDir(t, dir))
+		assert.Equal(t, "before\nafter\n", readFile(t, filepath.Join(dir, "app-2024-10-09T09-00-00.000.log")))
+		assert.Equal(t, "", readFile(t, filepath.Join(dir, "app.log")))
+	})
+
+	t.Run("should open the file again on the next Write if opening it fails", func(t *testing.T) {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "logs", "app.log")
+		w := newTestWriter(t, Config{Filename: filename}, nil)
+
+		// Replacing the directory by a file makes opening the log file fail:
+		assert.Equal(t, nil, os.Rename(filepath.Join(dir, "logs"), filepath.Join(dir, "old-logs")))
+		assert.Equal(t, nil, os.WriteFile(filepath.Join(dir, "logs"), nil, 0644))
+		assert.NotEqual(t, nil, w.Reopen())
+
+		_, err := w.Write([]byte("lost\n"))
+		assert.NotEqual(t, nil, err)
+
+		assert.Equal(t, nil, os.Remove(filepath.Join(dir, "logs")))
+		_, err = w.Write([]byte("after\n"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, "after\n", readFile(t, filename))
+	})
+
+	t.Run("should write to a new file after Reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		w := newTestWriter(t, Config{Filename: filepath.Join(dir, "app.log")}, nil)
+
+		_, _ = w.Write([]byte("before\n"))
+		assert.Equal(t, nil, os.Rename(filepath.Join(dir, "app.log"), filepath.Join(dir, "app.log.1")))
+		assert.Equal(t, nil, w.Reopen())
+		_, _ = w.Write([]byte("after\n"))
+		assert.Equal(t, nil, w.Close())
+
+		assert.Equal(t, "before\n", readFile(t, filepath.Join(dir, "app.log.1")))
+		assert.Equal(t, "after\n", readFile(t, filepath.Join(dir, "app.log")))
+	})
+}
+
+func newTestWriter(t *testing.T, config Config, now *time.Time) *Writer {
+	w, err := New(config)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %s", err)
+	}
+	if now != nil {
+		w.timeNow = func() time.Time {
+			return *now
+		}
+		w.openedAt = *now
+	}
+	return w
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read file '%s': %s", path, err)
+	}
+	return string(content)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read dir '%s': %s", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package rotatefile
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReopen reopens the file every time the process
+// receives a SIGHUP until the returned function is called.
+func notifyReopen(w *Writer) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				err := w.Reopen()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "rotatefile: error reopening log file on SIGHUP: %s\n", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
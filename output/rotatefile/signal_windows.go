@@ -0,0 +1,5 @@
+package rotatefile
+
+func notifyReopen(w *Writer) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,357 @@
+// Package syslog implements a klog Output that sends the log
+// entries to a syslog server using the RFC 5424 format.
+//
+// It supports sending the entries over UDP, TCP and unix sockets,
+// and the Body can either be rendered as RFC 5424 structured data
+// or as a JSON message.
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/netconn"
+)
+
+// Facility is the syslog facility as described on RFC 5424.
+type Facility int
+
+// The syslog facilities
+const (
+	Kern Facility = iota
+	User
+	Mail
+	Daemon
+	Auth
+	Syslog
+	Lpr
+	News
+	Uucp
+	Cron
+	AuthPriv
+	Ftp
+	_
+	_
+	_
+	_
+	Local0
+	Local1
+	Local2
+	Local3
+	Local4
+	Local5
+	Local6
+	Local7
+)
+
+// Format describes how the Body is rendered on the message.
+type Format int
+
+const (
+	// StructuredData renders the title as the message and
+	// the Body as a single RFC 5424 structured data element.
+	StructuredData Format = iota
+
+	// JSONMessage renders the whole entry as a JSON message,
+	// just like the default klog output.
+	JSONMessage
+)
+
+// Framing describes how messages are delimited on stream connections,
+// i.e. TCP and unix stream sockets, as described on RFC 6587.
+type Framing int
+
+const (
+	// OctetCounting prefixes each message with its length.
+	OctetCounting Framing = iota
+
+	// NonTransparent terminates each message with a line break.
+	NonTransparent
+)
+
+// Config contains the configurations for the syslog Output.
+type Config struct {
+	// Network is one of "udp", "tcp", "unix" or "unixgram".
+	//
+	// If left empty the local syslog daemon is used by trying
+	// the usual unix socket paths.
+	Network string
+	Address string
+
+	// Facility defaults to User.
+	//
+	// Kern can not be selected since it is the zero value and is
+	// reserved for messages generated by the kernel, which is also
+	// why syslog(3) replaces it by User for messages from processes.
+	Facility Facility
+
+	// AppName defaults to the name of the executable.
+	AppName string
+
+	// Hostname defaults to os.Hostname().
+	Hostname string
+
+	// ProcID defaults to the pid of the process.
+	ProcID string
+
+	// MsgID defaults to "-".
+	MsgID string
+
+	// Format defaults to StructuredData.
+	Format Format
+
+	// SDID is the ID of the structured data element,
+	// defaults to "klog@32473".
+	SDID string
+
+	// Framing is only used on stream connections
+	// and defaults to OctetCounting.
+	Framing Framing
+
+	// Timeout is used both for dialing and for writing, defaults to 5s.
+	Timeout time.Duration
+}
+
+var _ klog.Output = &Output{}
+
+// Output is a klog.Output that writes to a syslog server,
+// reconnecting automatically on write errors.
+type Output struct {
+	config Config
+
+	mutex   sync.Mutex
+	conn    *netconn.Conn
+	network string
+}
+
+// New dials the configured syslog server and returns an Output for it.
+func New(config Config) (*Output, error) {
+	if config.Facility == 0 {
+		config.Facility = User
+	}
+	if config.AppName == "" {
+		config.AppName = filepath.Base(os.Args[0])
+	}
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+	if config.ProcID == "" {
+		config.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if config.SDID == "" {
+		config.SDID = "klog@32473"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	o := &Output{
+		config: config,
+	}
+
+	conn, err := netconn.Dial(o.dial)
+	if err != nil {
+		return nil, err
+	}
+	o.conn = conn
+
+	return o, nil
+}
+
+// WriteLog implements the klog.Output interface
+func (o *Output) WriteLog(t time.Time, data *klog.LogData) error {
+	msg := o.format(t, data)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		return klog.ErrOutputClosed
+	}
+
+	return o.conn.Do(func(conn net.Conn) error {
+		return o.write(conn, msg)
+	})
+}
+
+// Close closes the connection with the syslog server.
+func (o *Output) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+func (o *Output) dial() (net.Conn, error) {
+	if o.config.Network != "" {
+		conn, err := net.DialTimeout(o.config.Network, o.config.Address, o.config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: error connecting to '%s': %w", o.config.Address, err)
+		}
+		o.network = o.config.Network
+		return conn, nil
+	}
+
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			conn, err := net.DialTimeout(network, path, o.config.Timeout)
+			if err == nil {
+				o.network = network
+				return conn, nil
+			}
+		}
+	}
+
+	return nil, errors.New("syslog: unable to connect to the local syslog daemon")
+}
+
+func (o *Output) write(conn net.Conn, msg string) error {
+	if o.isStream() {
+		if o.config.Framing == NonTransparent {
+			msg += "\n"
+		} else {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+	}
+
+	err := conn.SetWriteDeadline(time.Now().Add(o.config.Timeout))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+func (o *Output) isStream() bool {
+	switch o.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	default:
+		return false
+	}
+}
+
+func (o *Output) format(t time.Time, data *klog.LogData) string {
+	priority := int(o.config.Facility)*8 + Severity(data.Level)
+
+	var sd, msg string
+	if o.config.Format == JSONMessage {
+		sd = "-"
+		msg = klog.FormatJSON(t, data)
+	} else {
+		sd = formatStructuredData(o.config.SDID, data.Body)
+		msg = data.Title
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s",
+		priority,
+		t.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(o.config.Hostname, 255),
+		headerField(o.config.AppName, 48),
+		headerField(o.config.ProcID, 128),
+		headerField(o.config.MsgID, 32),
+		sd,
+		msg,
+	)
+}
+
+// Severity maps a klog level to its syslog severity.
+func Severity(level string) int {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return 7
+	case "INFO":
+		return 6
+	case "WARN":
+		return 4
+	case "ERROR":
+		return 3
+	default:
+		return 5
+	}
+}
+
+func formatStructuredData(id string, body klog.Body) string {
+	if len(body) == 0 {
+		return "-"
+	}
+
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("[")
+	sb.WriteString(sdName(id, 32))
+	for _, k := range keys {
+		value, ok := body[k].(string)
+		if !ok {
+			value = klog.EncodeJSON(body[k])
+		}
+
+		sb.WriteString(" ")
+		sb.WriteString(sdName(k, 32))
+		sb.WriteString(`="`)
+		sb.WriteString(sdValueEscaper.Replace(value))
+		sb.WriteString(`"`)
+	}
+	sb.WriteString("]")
+
+	return sb.String()
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName converts the input to a valid SD-NAME replacing
+// the invalid characters by underscores.
+func sdName(name string, maxLen int) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxLen {
+		b = b[:maxLen]
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// headerField converts the input to a valid header field
+// which must be printable ASCII or the NILVALUE "-".
+func headerField(value string, maxLen int) string {
+	b := []byte(value)
+	for i, c := range b {
+		if c <= ' ' || c > '~' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > maxLen {
+		b = b[:maxLen]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
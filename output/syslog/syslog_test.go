@@ -0,0 +1,197 @@
+package syslog
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+func TestOutput(t *testing.T) {
+	now := parseTime(t, "2024-10-09T09:00:00Z")
+	config := Config{
+		Facility: Local0,
+		AppName:  "fake-app",
+		Hostname: "fake-host",
+		ProcID:   "42",
+	}
+
+	tests := []struct {
+		desc            string
+		format          Format
+		data            klog.LogData
+		expectedMessage string
+	}{
+		{
+			desc:   "should render the body as structured data",
+			format: StructuredData,
+			data: klog.LogData{
+				Level: "WARN",
+				Title: "fake-title",
+				Body: klog.Body{
+					"user_id": 42,
+					"msg":     `some "quoted" [value]`,
+				},
+			},
+			expectedMessage: `<132>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - [klog@32473 msg="some \"quoted\" [value\]" user_id="42"] fake-title`,
+		},
+		{
+			desc:   "should use the NILVALUE for empty bodies",
+			format: StructuredData,
+			data: klog.LogData{
+				Level: "DEBUG",
+				Title: "fake-title",
+			},
+			expectedMessage: `<135>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - fake-title`,
+		},
+		{
+			desc:   "should render the entry as a JSON message",
+			format: JSONMessage,
+			data: klog.LogData{
+				Level: "ERROR",
+				Title: "fake-title",
+				Body:  klog.Body{"key": "value"},
+			},
+			expectedMessage: `<131>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - {"timestamp":"2024-10-09T09:00:00Z","level":"ERROR","title":"fake-title","key":"value"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			assert.Equal(t, nil, err)
+			defer func() { _ = conn.Close() }()
+
+			c := config
+			c.Network = "udp"
+			c.Address = conn.LocalAddr().String()
+			c.Format = test.format
+			output, err := New(c)
+			assert.Equal(t, nil, err)
+			defer func() { _ = output.Close() }()
+
+			err = output.WriteLog(now, &test.data)
+			assert.Equal(t, nil, err)
+
+			assert.Equal(t, test.expectedMessage, readPacket(t, conn))
+		})
+	}
+
+	t.Run("should write to unix datagram sockets", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log.sock")
+		conn, err := net.ListenPacket("unixgram", path)
+		assert.Equal(t, nil, err)
+		defer func() { _ = conn.Close() }()
+
+		c := config
+		c.Network = "unixgram"
+		c.Address = path
+		output, err := New(c)
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		err = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "fake-title"})
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, `<134>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - fake-title`, readPacket(t, conn))
+	})
+
+	t.Run("should use octet counting over TCP and reconnect after failures", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = listener.Close() }()
+
+		c := config
+		c.Network = "tcp"
+		c.Address = listener.Addr().String()
+		output, err := New(c)
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		serverConn, err := listener.Accept()
+		assert.Equal(t, nil, err)
+
+		err = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "first"})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `<134>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - first`, readFrame(t, bufio.NewReader(serverConn)))
+
+		// Simulate a server restart:
+		_ = serverConn.Close()
+		_ = output.conn.Close()
+
+		err = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "second"})
+		assert.Equal(t, nil, err)
+
+		serverConn, err = listener.Accept()
+		assert.Equal(t, nil, err)
+		defer func() { _ = serverConn.Close() }()
+		assert.Equal(t, `<134>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - second`, readFrame(t, bufio.NewReader(serverConn)))
+	})
+
+	t.Run("should use line breaks with non transparent framing", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Equal(t, nil, err)
+		defer func() { _ = listener.Close() }()
+
+		c := config
+		c.Network = "tcp"
+		c.Address = listener.Addr().String()
+		c.Framing = NonTransparent
+		output, err := New(c)
+		assert.Equal(t, nil, err)
+		defer func() { _ = output.Close() }()
+
+		serverConn, err := listener.Accept()
+		assert.Equal(t, nil, err)
+		defer func() { _ = serverConn.Close() }()
+
+		err = output.WriteLog(now, &klog.LogData{Level: "INFO", Title: "fake-title"})
+		assert.Equal(t, nil, err)
+
+		_ = serverConn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := bufio.NewReader(serverConn).ReadString('\n')
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `<134>1 2024-10-09T09:00:00.000000Z fake-host fake-app 42 - - fake-title`+"\n", line)
+	})
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unable to read packet: %s", err)
+	}
+	return string(buf[:n])
+}
+
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatalf("unable to read frame length: %s", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatalf("invalid frame length '%s': %s", length, err)
+	}
+
+	buf := make([]byte, n)
+	_, err = r.Read(buf)
+	if err != nil {
+		t.Fatalf("unable to read frame: %s", err)
+	}
+	return string(buf)
+}
+
+func parseTime(t *testing.T, timeStr string) time.Time {
+	dateTime, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		t.Fatalf("unable to parse input time string '%s' as RFC3339: %s", timeStr, err)
+	}
+	return dateTime.UTC()
+}
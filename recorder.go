@@ -0,0 +1,188 @@
+package klog
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlightRecorder keeps the last entries logged by a Client, of all
+// levels and independent of the level of the Client, so that the
+// context of an incident is available without logging at "DEBUG".
+//
+// The recorded entries that were not written, e.g. because of the
+// level, are written as a "flashback", i.e. with the "flashback" key
+// set to true on their Body, right before each "ERROR" entry, and all
+// the recorded entries can also be dumped on demand.
+//
+// The entries below the level of the Client are recorded without
+// running the ContextParsers, the middlewares or the LogValue methods,
+// which only run if the entry is ever dumped.
+type FlightRecorder struct {
+	mutex   sync.Mutex
+	entries []*recordedEntry
+	head    int
+	size    int
+}
+
+type recordedEntry struct {
+	time    time.Time
+	data    LogData
+	written bool
+
+	// build is set for the entries that were not written
+	// and returns their data once they are first dumped.
+	build func() LogData
+	once  sync.Once
+}
+
+// flashbackEntry is a copy of a recorded entry
+// with the flashback marker added to its Body.
+type flashbackEntry struct {
+	time time.Time
+	data LogData
+}
+
+// load returns a copy of the data of the entry, building it only
+// once for the lazy entries so that ServeHTTP can be called many
+// times without running the middlewares again.
+func (e *recordedEntry) load() LogData {
+	e.once.Do(func() {
+		if e.build != nil {
+			e.data = e.build()
+			e.build = nil
+		}
+	})
+	return copyLogData(&e.data)
+}
+
+// NewFlightRecorder returns a FlightRecorder that keeps the
+// last size entries, if size is not positive it defaults to 100.
+func NewFlightRecorder(size int) *FlightRecorder {
+	if size <= 0 {
+		size = 100
+	}
+	return &FlightRecorder{
+		entries: make([]*recordedEntry, size),
+	}
+}
+
+// SetFlightRecorder makes the Client record all the entries
+// on the received FlightRecorder, or stop recording if it is nil.
+//
+// Note that with a FlightRecorder set the entries below the level
+// of the Client are kept in memory until they are dumped.
+func (c *Client) SetFlightRecorder(r *FlightRecorder) {
+	c.recorder = r
+}
+
+// Dump writes all the recorded entries, marked as a flashback,
+// to the received Output and clears the FlightRecorder.
+func (r *FlightRecorder) Dump(out Output) error {
+	var firstErr error
+	for _, entry := range r.snapshot(true, false) {
+		err := out.WriteLog(entry.time, &entry.data)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ServeHTTP writes the recorded entries as JSON lines, marked as a
+// flashback, without clearing them, so the FlightRecorder can be
+// exposed on an internal HTTP endpoint:
+//
+//	http.Handle("/debug/klog/flashback", recorder)
+func (r *FlightRecorder) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var sb strings.Builder
+	for _, entry := range r.snapshot(false, false) {
+		sb.WriteString(FormatJSON(entry.time, &entry.data))
+		sb.WriteString("\n")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_, _ = io.WriteString(w, sb.String())
+}
+
+// record records an entry that was built, returning it so that
+// entries held by a request buffer can be marked as written later.
+func (r *FlightRecorder) record(t time.Time, data *LogData, written bool) *recordedEntry {
+	entry := &recordedEntry{
+		time:    t,
+		data:    copyLogData(data),
+		written: written,
+	}
+	r.add(entry)
+	return entry
+}
+
+func (r *FlightRecorder) recordLazily(t time.Time, build func() LogData) {
+	r.add(&recordedEntry{
+		time:  t,
+		build: build,
+	})
+}
+
+// markWritten prevents the entry from being written again by dump.
+func (r *FlightRecorder) markWritten(entry *recordedEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry.written = true
+}
+
+func (r *FlightRecorder) add(entry *recordedEntry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.entries[(r.head+r.size)%len(r.entries)] = entry
+	if r.size < len(r.entries) {
+		r.size++
+	} else {
+		r.head = (r.head + 1) % len(r.entries)
+	}
+}
+
+// dump writes the recorded entries that were not
+// written yet and clears the FlightRecorder.
+func (r *FlightRecorder) dump(write func(t time.Time, data *LogData)) {
+	for _, entry := range r.snapshot(true, true) {
+		write(entry.time, &entry.data)
+	}
+}
+
+// snapshot returns the recorded entries in the order they were
+// logged with the flashback marker added to their bodies.
+func (r *FlightRecorder) snapshot(clear bool, onlyUnwritten bool) []flashbackEntry {
+	r.mutex.Lock()
+	entries := make([]*recordedEntry, 0, r.size)
+	for i := 0; i < r.size; i++ {
+		entry := r.entries[(r.head+i)%len(r.entries)]
+		if onlyUnwritten && entry.written {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if clear {
+		for i := range r.entries {
+			r.entries[i] = nil
+		}
+		r.head = 0
+		r.size = 0
+	}
+	r.mutex.Unlock()
+
+	flashback := make([]flashbackEntry, 0, len(entries))
+	for _, entry := range entries {
+		data := entry.load()
+		data.Body["flashback"] = true
+		flashback = append(flashback, flashbackEntry{
+			time: entry.time,
+			data: data,
+		})
+	}
+	return flashback
+}
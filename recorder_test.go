@@ -0,0 +1,223 @@
+package klog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	type entry struct {
+		title     string
+		flashback interface{}
+	}
+
+	newClient := func(recorder *FlightRecorder, entries *[]entry) *Client {
+		client := New("WARN")
+		client.OutputHandler = func(data *LogData) {
+			*entries = append(*entries, entry{title: data.Title, flashback: data.Body["flashback"]})
+		}
+		client.SetFlightRecorder(recorder)
+		return client
+	}
+
+	t.Run("should dump the entries that were not written before an error", func(t *testing.T) {
+		var entries []entry
+		client := newClient(NewFlightRecorder(10), &entries)
+
+		ctx := context.TODO()
+		client.Debug(ctx, "debug")
+		client.Info(ctx, "info")
+		client.Warn(ctx, "warn")
+		assert.Equal(t, []entry{{title: "warn"}}, entries)
+
+		client.Error(ctx, "error")
+		assert.Equal(t, []entry{
+			{title: "warn"},
+			{title: "debug", flashback: true},
+			{title: "info", flashback: true},
+			{title: "error"},
+		}, entries)
+
+		// The recorder is cleared after each dump:
+		entries = nil
+		client.Info(ctx, "info-after-error")
+		client.Error(ctx, "second-error")
+		assert.Equal(t, []entry{
+			{title: "info-after-error", flashback: true},
+			{title: "second-error"},
+		}, entries)
+	})
+
+	t.Run("should only process the entries below the level when they are dumped", func(t *testing.T) {
+		var entries []entry
+		parserCalls := 0
+		middlewareCalls := 0
+		valuerCalls := 0
+
+		client := New("WARN", func(ctx context.Context) Body {
+			parserCalls++
+			return Body{"request_id": "fake-id"}
+		})
+		client.AddBeforeEach(func(ctx context.Context, data *LogData) error {
+			middlewareCalls++
+			return nil
+		})
+		client.OutputHandler = func(data *LogData) {
+			assert.Equal(t, "fake-id", data.Body["request_id"])
+			entries = append(entries, entry{title: data.Title, flashback: data.Body["flashback"]})
+		}
+		client.SetFlightRecorder(NewFlightRecorder(10))
+
+		client.Debug(context.TODO(), "debug", Body{
+			"value": fakeFunc(func() interface{} {
+				valuerCalls++
+				return "value"
+			}),
+		})
+		assert.Equal(t, 0, parserCalls)
+		assert.Equal(t, 0, middlewareCalls)
+		assert.Equal(t, 0, valuerCalls)
+
+		client.Error(context.TODO(), "error")
+		assert.Equal(t, 2, parserCalls)
+		assert.Equal(t, 2, middlewareCalls)
+		assert.Equal(t, 1, valuerCalls)
+		assert.Equal(t, []entry{
+			{title: "debug", flashback: true},
+			{title: "error"},
+		}, entries)
+	})
+
+	t.Run("should not dump the buffered entries that were written", func(t *testing.T) {
+		var entries []entry
+		client := newClient(NewFlightRecorder(10), &entries)
+
+		ctx := ContextWithBuffer(context.TODO(), 10)
+		client.Debug(ctx, "debug")
+		client.Info(ctx, "info")
+		assert.Equal(t, 0, len(entries))
+
+		client.Error(ctx, "error")
+		assert.Equal(t, []entry{
+			{title: "debug"},
+			{title: "info"},
+			{title: "error"},
+		}, entries)
+	})
+
+	t.Run("should dump the buffered entries that were discarded", func(t *testing.T) {
+		var entries []entry
+		client := newClient(NewFlightRecorder(10), &entries)
+
+		ctx := ContextWithBuffer(context.TODO(), 10)
+		client.Info(ctx, "info")
+		DiscardBuffer(ctx)
+		assert.Equal(t, 0, len(entries))
+
+		client.Error(context.TODO(), "error")
+		assert.Equal(t, []entry{
+			{title: "info", flashback: true},
+			{title: "error"},
+		}, entries)
+	})
+
+	t.Run("should keep only the last entries", func(t *testing.T) {
+		var entries []entry
+		client := newClient(NewFlightRecorder(2), &entries)
+
+		for i := 0; i < 5; i++ {
+			client.Debug(context.TODO(), fmt.Sprint(i))
+		}
+		client.Error(context.TODO(), "error")
+
+		assert.Equal(t, []entry{
+			{title: "3", flashback: true},
+			{title: "4", flashback: true},
+			{title: "error"},
+		}, entries)
+	})
+
+	t.Run("should dump all the entries on demand", func(t *testing.T) {
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+
+		recorder := NewFlightRecorder(10)
+		client := New("ERROR")
+		client.timeNow = func() time.Time {
+			return now
+		}
+		client.SetFlightRecorder(recorder)
+
+		client.Debug(context.TODO(), "debug", Body{"key": "value"})
+
+		var output strings.Builder
+		err := recorder.Dump(NewJSONOutput(&output))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"timestamp":"2024-10-09T09:00:00Z","level":"DEBUG","title":"debug","flashback":true,"key":"value"}`+"\n", output.String())
+
+		output.Reset()
+		err = recorder.Dump(NewJSONOutput(&output))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "", output.String())
+	})
+
+	t.Run("should serve the entries over HTTP without clearing them", func(t *testing.T) {
+		recorder := NewFlightRecorder(10)
+		client := New("ERROR")
+		client.timeNow = func() time.Time {
+			return parseTime(t, "2024-10-09T09:00:00Z")
+		}
+		client.SetFlightRecorder(recorder)
+
+		client.Info(context.TODO(), "info")
+
+		for i := 0; i < 2; i++ {
+			resp := httptest.NewRecorder()
+			recorder.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, "application/x-ndjson", resp.Header().Get("Content-Type"))
+			assert.Equal(t, `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"info","flashback":true}`+"\n", resp.Body.String())
+		}
+	})
+
+	t.Run("should build the entries below the level only once", func(t *testing.T) {
+		middlewareCalls := 0
+
+		recorder := NewFlightRecorder(10)
+		client := New("ERROR")
+		client.AddBeforeEach(func(ctx context.Context, data *LogData) error {
+			middlewareCalls++
+			return nil
+		})
+		client.SetFlightRecorder(recorder)
+
+		client.Info(context.TODO(), "info")
+
+		for i := 0; i < 2; i++ {
+			recorder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+		assert.Equal(t, 1, middlewareCalls)
+	})
+
+	t.Run("should not panic when a middleware removes the Body", func(t *testing.T) {
+		var entries []entry
+		client := newClient(NewFlightRecorder(10), &entries)
+		client.AddBeforeEach(func(ctx context.Context, data *LogData) error {
+			data.Body = nil
+			return nil
+		})
+
+		client.Debug(context.TODO(), "debug")
+		client.Error(context.TODO(), "error")
+		assert.Equal(t, []entry{
+			{title: "debug", flashback: true},
+			{title: "error"},
+		}, entries)
+	})
+}
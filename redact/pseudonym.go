@@ -0,0 +1,152 @@
+package redact
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/vingarcia/klog"
+)
+
+// Key is a secret used for computing pseudonyms, its ID is part of
+// the pseudonyms so that it is clear which key produced each one.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// PseudonymConfig contains the configurations for the Pseudonymizer.
+type PseudonymConfig struct {
+	// Fields maps the keys of the Body that should be pseudonymized to
+	// the prefix of their pseudonyms, e.g. {"user_id": "usr_", "ip": "ip_"}.
+	//
+	// The keys are matched the same way as Config.Keys, at any depth,
+	// and the prefix is also part of the HMAC input, so fields with
+	// different prefixes never produce the same pseudonym.
+	Fields map[string]string
+
+	// Keys are the HMAC keys, the first one is used for new entries
+	// and the others are only used by Pseudonyms, so that values can
+	// still be searched on entries written before a rotation.
+	Keys []Key
+
+	// Length is the number of hex digits of the pseudonyms, defaults to 16.
+	Length int
+}
+
+// Pseudonymizer replaces identifiers, like user IDs and IPs, by stable
+// pseudonyms based on HMAC-SHA256, so the same value always maps to
+// the same pseudonym without revealing the original value.
+type Pseudonymizer struct {
+	fields   map[string]string
+	length   int
+	redactor *redactor
+
+	mutex sync.RWMutex
+	keys  []Key
+}
+
+// NewPseudonymizer returns a Pseudonymizer, at least one Key is required.
+func NewPseudonymizer(config PseudonymConfig) *Pseudonymizer {
+	if config.Length <= 0 || config.Length > 2*sha256.Size {
+		config.Length = 16
+	}
+
+	fields := map[string]string{}
+	for k, prefix := range config.Fields {
+		fields[normalizeKey(k)] = prefix
+	}
+
+	p := &Pseudonymizer{
+		fields: fields,
+		length: config.Length,
+	}
+	p.SetKeys(config.Keys...)
+
+	p.redactor = newRedactor(Config{
+		Keys:     []string{},
+		Patterns: []Pattern{},
+	})
+	p.redactor.replace = p.replace
+
+	return p
+}
+
+// SetKeys replaces the HMAC keys, the first one is used for new entries.
+func (p *Pseudonymizer) SetKeys(keys ...Key) {
+	if len(keys) == 0 {
+		panic("redact: at least one pseudonymization Key is required")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.keys = append([]Key(nil), keys...)
+}
+
+// Middleware returns a klog.Middleware that pseudonymizes the
+// configured fields, it should be registered with AddBeforeEach.
+func (p *Pseudonymizer) Middleware() klog.Middleware {
+	return func(ctx context.Context, data *klog.LogData) error {
+		data.Body = p.redactor.redactBody(data.Body)
+		return nil
+	}
+}
+
+// Pseudonym returns the pseudonym of the value for the
+// received field using the current key.
+func (p *Pseudonymizer) Pseudonym(field string, value interface{}) string {
+	p.mutex.RLock()
+	key := p.keys[0]
+	p.mutex.RUnlock()
+
+	return p.pseudonym(key, p.fields[normalizeKey(field)], value)
+}
+
+// Pseudonyms returns the pseudonyms of the value for the received
+// field using each of the keys, which is useful for searching the
+// entries of a value written before and after key rotations.
+func (p *Pseudonymizer) Pseudonyms(field string, value interface{}) []string {
+	p.mutex.RLock()
+	keys := p.keys
+	p.mutex.RUnlock()
+
+	prefix := p.fields[normalizeKey(field)]
+	pseudonyms := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pseudonyms = append(pseudonyms, p.pseudonym(key, prefix, value))
+	}
+	return pseudonyms
+}
+
+func (p *Pseudonymizer) replace(key string, value interface{}) (interface{}, bool) {
+	prefix, ok := p.fields[normalizeKey(key)]
+	if !ok || value == nil {
+		return nil, false
+	}
+
+	p.mutex.RLock()
+	current := p.keys[0]
+	p.mutex.RUnlock()
+
+	return p.pseudonym(current, prefix, value), true
+}
+
+func (p *Pseudonymizer) pseudonym(key Key, prefix string, value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		s = klog.EncodeJSON(value)
+	}
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(prefix))
+	mac.Write([]byte{0})
+	mac.Write([]byte(s))
+	sum := hex.EncodeToString(mac.Sum(nil))[:p.length]
+
+	if key.ID == "" {
+		return prefix + sum
+	}
+	return prefix + key.ID + "_" + sum
+}
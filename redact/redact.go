@@ -0,0 +1,469 @@
+// Package redact provides a klog Middleware that removes secrets
+// and personal information from the Body of the log entries.
+//
+// The Body is walked recursively, including nested maps, slices,
+// structs and the JSON representation of json.Marshaler values,
+// and values are redacted when their key matches one
+// of the configured key names, when they contain a match for one of
+// the configured patterns or when a custom predicate matches them:
+//
+//	logger.AddBeforeEach(redact.Middleware(redact.Config{}))
+//
+// Identifiers that must still be correlated across entries, like user
+// IDs and IPs, can be replaced by stable pseudonyms with a Pseudonymizer.
+package redact
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vingarcia/klog"
+	"github.com/vingarcia/klog/internal/fields"
+	"github.com/vingarcia/klog/internal/visit"
+)
+
+// Mode describes how a value is redacted.
+type Mode int
+
+const (
+	// Mask replaces the value by the configured mask.
+	Mask Mode = iota
+
+	// Drop removes the value from the Body.
+	Drop
+
+	// PartialMask replaces all but the last 4 characters of the value
+	// by asterisks, values with up to 8 characters are fully masked.
+	PartialMask
+
+	// Hash replaces the value by the first 16 hex digits of its
+	// HMAC-SHA256, keyed with Config.HashKey, prefixed with "hmac:",
+	// so that equal values can still be correlated but short values,
+	// like phone numbers, can't be recovered by hashing guesses.
+	Hash
+)
+
+// Pattern redacts the parts of string values matching Regexp.
+//
+// When its Mode is Drop the whole value containing the match is dropped.
+type Pattern struct {
+	Regexp *regexp.Regexp
+	Mode   Mode
+
+	// Validate, if set, is called for each match to
+	// filter false positives, e.g. with a checksum.
+	Validate func(match string) bool
+}
+
+// Predicate is called for every value of the Body, including the
+// nested ones, with its key and returns whether it should be redacted
+// and how. Values inside slices receive the key of the slice.
+type Predicate func(key string, value interface{}) (mode Mode, redact bool)
+
+// The patterns used by default.
+var (
+	CreditCardPattern = Pattern{
+		Regexp:   regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Mode:     PartialMask,
+		Validate: luhn,
+	}
+	EmailPattern = Pattern{
+		Regexp: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		Mode:   Mask,
+	}
+	JWTPattern = Pattern{
+		Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+		Mode:   Mask,
+	}
+)
+
+// DefaultKeys are the key names redacted by default.
+var DefaultKeys = []string{
+	"password", "passwd", "secret", "token", "access_token", "refresh_token",
+	"authorization", "api_key", "cookie", "set_cookie", "private_key", "client_secret",
+}
+
+// Config contains the configurations for the redaction Middleware.
+type Config struct {
+	// Keys are the names of the keys whose values are redacted, they are
+	// matched ignoring case, underscores, dashes and dots, so "api_key"
+	// also matches "apiKey" and "API-Key". Defaults to DefaultKeys.
+	Keys []string
+
+	// KeyMode is the Mode used for the values of the Keys, defaults to Mask.
+	KeyMode Mode
+
+	// Patterns defaults to the credit card, email and JWT patterns,
+	// use an empty non nil slice to disable them.
+	Patterns []Pattern
+
+	// Predicates are checked before the Keys and Patterns.
+	Predicates []Predicate
+
+	// Mask defaults to "[REDACTED]".
+	Mask string
+
+	// HashKey is the HMAC key used by the Hash mode, defaults to a
+	// random key, in which case the hashes can only be correlated
+	// between entries written by the same process.
+	HashKey []byte
+}
+
+// maxDepth limits how deep the Body is walked, anything
+// deeper than that is replaced by the mask.
+const maxDepth = 32
+
+// cycleMarker replaces the values that contain themselves,
+// the same way the klog encoder does.
+const cycleMarker = "<cycle>"
+
+type redactor struct {
+	config Config
+	keys   map[string]bool
+
+	// replace, if set, is checked before anything else and
+	// returns the replacement for the value if it matches.
+	replace func(key string, value interface{}) (interface{}, bool)
+}
+
+// Middleware returns a klog.Middleware that redacts the Body of
+// the entries, it should be registered with AddBeforeEach.
+//
+// The nested values are copied before being redacted, so the
+// values passed to the Provider are never modified.
+func Middleware(config Config) klog.Middleware {
+	r := newRedactor(config)
+
+	return func(ctx context.Context, data *klog.LogData) error {
+		data.Body = r.redactBody(data.Body)
+		return nil
+	}
+}
+
+func newRedactor(config Config) *redactor {
+	if config.Keys == nil {
+		config.Keys = DefaultKeys
+	}
+	if config.Patterns == nil {
+		config.Patterns = []Pattern{CreditCardPattern, EmailPattern, JWTPattern}
+	}
+	if config.Mask == "" {
+		config.Mask = "[REDACTED]"
+	}
+	if len(config.HashKey) == 0 {
+		config.HashKey = make([]byte, 32)
+		if _, err := rand.Read(config.HashKey); err != nil {
+			panic("redact: unable to generate the HashKey: " + err.Error())
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, k := range config.Keys {
+		keys[normalizeKey(k)] = true
+	}
+
+	return &redactor{
+		config: config,
+		keys:   keys,
+	}
+}
+
+func (r *redactor) redactBody(body klog.Body) klog.Body {
+	redacted := make(klog.Body, len(body))
+	var visiting visit.Set
+	for k, v := range body {
+		value, keep := r.redactField(k, v, &visiting, 0)
+		if keep {
+			redacted[k] = value
+		}
+	}
+	return redacted
+}
+
+// redactField redacts a value and returns false if it should be dropped.
+func (r *redactor) redactField(key string, value interface{}, visiting *visit.Set, depth int) (interface{}, bool) {
+	if r.replace != nil {
+		if replacement, ok := r.replace(key, value); ok {
+			return replacement, true
+		}
+	}
+
+	for _, predicate := range r.config.Predicates {
+		if mode, ok := predicate(key, value); ok {
+			return r.apply(mode, value)
+		}
+	}
+
+	if r.keys[normalizeKey(key)] {
+		return r.apply(r.config.KeyMode, value)
+	}
+
+	return r.redactValue(key, value, visiting, depth)
+}
+
+// redactValue walks the value keeping the pointers, maps and slices
+// being visited on the visiting set in order to detect cycles.
+func (r *redactor) redactValue(key string, value interface{}, visiting *visit.Set, depth int) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	if depth >= maxDepth {
+		return r.config.Mask, true
+	}
+
+	switch v := value.(type) {
+	case string:
+		return r.redactString(v)
+	case json.Marshaler:
+		return r.redactJSON(key, v, visiting, depth)
+	case []byte, encoding.TextMarshaler, error, fmt.Stringer:
+		// These types control their own representation, so only
+		// the resulting string is checked against the patterns:
+		s, ok := stringify(v)
+		if !ok {
+			return value, true
+		}
+		redacted, keep := r.redactString(s)
+		if redacted == s {
+			return value, keep
+		}
+		return redacted, keep
+	}
+
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, true
+		}
+		if rv.Kind() == reflect.Ptr {
+			if !visiting.Enter(rv) {
+				return cycleMarker, true
+			}
+			defer visiting.Leave(rv)
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		if !visiting.Enter(rv) {
+			return cycleMarker, true
+		}
+		defer visiting.Leave(rv)
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return r.redactString(rv.String())
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			k := fmt.Sprint(iter.Key().Interface())
+			if iter.Key().Kind() == reflect.String {
+				k = iter.Key().String()
+			}
+			v, keep := r.redactField(k, iter.Value().Interface(), visiting, depth+1)
+			if keep {
+				m[k] = v
+			}
+		}
+		return m, true
+	case reflect.Slice, reflect.Array:
+		s := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			v, keep := r.redactField(key, rv.Index(i).Interface(), visiting, depth+1)
+			if keep {
+				s = append(s, v)
+			}
+		}
+		return s, true
+	case reflect.Struct:
+		return r.redactStruct(rv, visiting, depth), true
+	default:
+		return value, true
+	}
+}
+
+// redactJSON walks the JSON representation of the value, so the
+// contents of values like json.RawMessage are also redacted, keeping
+// the original value if it is a string that needs no redaction.
+func (r *redactor) redactJSON(key string, value json.Marshaler, visiting *visit.Set, depth int) (interface{}, bool) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value, true
+	}
+
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	err = dec.Decode(&decoded)
+	if err != nil {
+		return value, true
+	}
+
+	if s, ok := decoded.(string); ok {
+		redacted, keep := r.redactString(s)
+		if redacted == s {
+			return value, keep
+		}
+		return redacted, keep
+	}
+	return r.redactValue(key, decoded, visiting, depth)
+}
+
+// redactStruct converts the struct into a map using the same field
+// rules as klog, which follow encoding/json, so the fields of embedded
+// structs are promoted unless the outer struct has a field with the
+// same name, even if that field was dropped.
+func (r *redactor) redactStruct(rv reflect.Value, visiting *visit.Set, depth int) map[string]interface{} {
+	m := map[string]interface{}{}
+	taken := map[string]bool{}
+	var promoted []map[string]interface{}
+	for _, f := range fields.Of(rv.Type(), "json") {
+		fv := rv.Field(f.Index)
+		if f.OmitEmpty && fv.IsZero() {
+			continue
+		}
+
+		if f.Inline {
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+
+			v, _ := r.redactValue(f.Name, fv.Interface(), visiting, depth+1)
+			if embedded, ok := v.(map[string]interface{}); ok {
+				promoted = append(promoted, embedded)
+				continue
+			}
+			if v == cycleMarker {
+				continue
+			}
+		}
+
+		taken[f.Name] = true
+		v, keep := r.redactField(f.Name, fv.Interface(), visiting, depth+1)
+		if keep {
+			m[f.Name] = v
+		}
+	}
+
+	for _, embedded := range promoted {
+		for k, v := range embedded {
+			if !taken[k] {
+				taken[k] = true
+				m[k] = v
+			}
+		}
+	}
+	return m
+}
+
+func (r *redactor) redactString(s string) (interface{}, bool) {
+	for _, pattern := range r.config.Patterns {
+		dropped := false
+		s = pattern.Regexp.ReplaceAllStringFunc(s, func(match string) string {
+			if pattern.Validate != nil && !pattern.Validate(match) {
+				return match
+			}
+			if pattern.Mode == Drop {
+				dropped = true
+				return match
+			}
+			redacted, _ := r.apply(pattern.Mode, match)
+			return redacted.(string)
+		})
+		if dropped {
+			return nil, false
+		}
+	}
+	return s, true
+}
+
+func (r *redactor) apply(mode Mode, value interface{}) (interface{}, bool) {
+	switch mode {
+	case Drop:
+		return nil, false
+	case PartialMask:
+		s := fmt.Sprint(value)
+		n := utf8.RuneCountInString(s)
+		if n <= 8 {
+			return strings.Repeat("*", n), true
+		}
+		runes := []rune(s)
+		return strings.Repeat("*", n-4) + string(runes[n-4:]), true
+	case Hash:
+		s, ok := value.(string)
+		if !ok {
+			s = klog.EncodeJSON(value)
+		}
+		mac := hmac.New(sha256.New, r.config.HashKey)
+		mac.Write([]byte(s))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8]), true
+	default:
+		return r.config.Mask, true
+	}
+}
+
+func stringify(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case []byte:
+		if !utf8.Valid(v) {
+			return "", false
+		}
+		return string(v), true
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		return string(b), err == nil
+	case error:
+		return v.Error(), true
+	case fmt.Stringer:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '_', '-', '.':
+			return -1
+		}
+		return r
+	}, strings.ToLower(key))
+}
+
+// luhn reports whether the digits of the input pass the Luhn checksum.
+func luhn(s string) bool {
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
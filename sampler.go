@@ -0,0 +1,235 @@
+package klog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SamplerConfig contains the optional configurations for the Sampler.
+type SamplerConfig struct {
+	// Interval is the duration of each sampling window, defaults to 1s.
+	Interval time.Duration
+
+	// First is the number of entries with the same level and
+	// title that are written on each interval before sampling
+	// starts, defaults to 100.
+	First int
+
+	// Thereafter makes the Sampler write every Mth entry after
+	// the First ones, defaults to 100, use a negative value
+	// to drop all of them.
+	Thereafter int
+
+	// ExemptErrors makes the Sampler write all the "ERROR" entries.
+	ExemptErrors bool
+}
+
+var _ Output = &Sampler{}
+
+// Sampler is an Output that limits the number of entries with
+// the same level and title written to another Output, so that
+// a hot loop can't flood the logs.
+//
+// At the end of each interval the Sampler writes a WARN entry
+// titled "log-entries-suppressed" for each level and title that
+// had entries dropped, with the number of dropped entries.
+type Sampler struct {
+	out    Output
+	config SamplerConfig
+	now    func() time.Time
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	counters    map[samplerKey]*samplerCounter
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type samplerKey struct {
+	level string
+	title string
+}
+
+type samplerCounter struct {
+	seen       int
+	suppressed int
+}
+
+// NewSampler returns a Sampler that writes the sampled entries
+// to the received Output.
+//
+// Close must be called to stop the goroutine that writes the
+// summaries and to write the summary of the current interval.
+func NewSampler(out Output, config SamplerConfig) *Sampler {
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.First <= 0 {
+		config.First = 100
+	}
+	if config.Thereafter == 0 {
+		config.Thereafter = 100
+	}
+
+	s := &Sampler{
+		out:      out,
+		config:   config,
+		now:      time.Now,
+		counters: map[samplerKey]*samplerCounter{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// WriteLog implements the Output interface
+func (s *Sampler) WriteLog(t time.Time, data *LogData) error {
+	if s.config.ExemptErrors && strings.ToUpper(data.Level) == "ERROR" {
+		return s.out.WriteLog(t, data)
+	}
+
+	s.mutex.Lock()
+	now := s.now()
+	var summaries []LogData
+	if now.Sub(s.windowStart) >= s.config.Interval {
+		summaries = s.rollover(now)
+	}
+
+	key := samplerKey{level: data.Level, title: data.Title}
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &samplerCounter{}
+		s.counters[key] = counter
+	}
+	counter.seen++
+
+	write := counter.seen <= s.config.First ||
+		s.config.Thereafter > 0 && (counter.seen-s.config.First)%s.config.Thereafter == 0
+	if !write {
+		counter.suppressed++
+	}
+	s.mutex.Unlock()
+
+	s.writeSummaries(now, summaries)
+
+	if !write {
+		return nil
+	}
+	return s.out.WriteLog(t, data)
+}
+
+// Flush flushes the underlying Output if it is a Flusher.
+func (s *Sampler) Flush() error {
+	if f, ok := s.out.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close writes the summary of the current interval, stops
+// the Sampler goroutine and then closes the underlying Output
+// if it has a Close method.
+//
+// Calling Close more than once is safe.
+func (s *Sampler) Close() error {
+	closed := false
+	s.closeOnce.Do(func() {
+		closed = true
+		close(s.stop)
+	})
+	if !closed {
+		return nil
+	}
+	<-s.done
+
+	s.mutex.Lock()
+	now := s.now()
+	summaries := s.rollover(now)
+	s.mutex.Unlock()
+
+	s.writeSummaries(now, summaries)
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	if c, ok := s.out.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *Sampler) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		now := s.now()
+		var summaries []LogData
+		if now.Sub(s.windowStart) >= s.config.Interval {
+			summaries = s.rollover(now)
+		}
+		s.mutex.Unlock()
+
+		s.writeSummaries(now, summaries)
+	}
+}
+
+// rollover starts a new interval and returns the summaries
+// of the previous one, it must be called with the mutex locked.
+func (s *Sampler) rollover(now time.Time) []LogData {
+	var summaries []LogData
+	for key, counter := range s.counters {
+		if counter.suppressed == 0 {
+			continue
+		}
+
+		summaries = append(summaries, LogData{
+			Level: "WARN",
+			Title: "log-entries-suppressed",
+			Body: Body{
+				"sampled_level":  key.level,
+				"sampled_title":  key.title,
+				"suppressed":     counter.suppressed,
+				"interval_start": s.windowStart.Format(time.RFC3339),
+				"interval_end":   now.Format(time.RFC3339),
+			},
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i].Body, summaries[j].Body
+		if a["sampled_title"] != b["sampled_title"] {
+			return a["sampled_title"].(string) < b["sampled_title"].(string)
+		}
+		return LevelPriority(a["sampled_level"].(string)) < LevelPriority(b["sampled_level"].(string))
+	})
+
+	s.windowStart = now
+	s.counters = map[samplerKey]*samplerCounter{}
+
+	return summaries
+}
+
+func (s *Sampler) writeSummaries(now time.Time, summaries []LogData) {
+	for i := range summaries {
+		err := s.out.WriteLog(now, &summaries[i])
+		if err != nil {
+			reportOutputError(err, &summaries[i])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package klog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	newSampler := func(config SamplerConfig, entries *[]LogData) (*Sampler, *time.Time) {
+		now := parseTime(t, "2024-10-09T09:00:00Z")
+		config.Interval = time.Hour
+
+		sampler := NewSampler(OutputFunc(func(_ time.Time, data *LogData) error {
+			*entries = append(*entries, *data)
+			return nil
+		}), config)
+		sampler.now = func() time.Time {
+			return now
+		}
+		return sampler, &now
+	}
+
+	t.Run("should write the first entries and then every Mth", func(t *testing.T) {
+		var entries []LogData
+		sampler, _ := newSampler(SamplerConfig{First: 2, Thereafter: 3}, &entries)
+		defer func() { _ = sampler.Close() }()
+
+		for i := 0; i < 10; i++ {
+			err := sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "fake-title", Body: Body{"i": i}})
+			assert.Equal(t, nil, err)
+		}
+
+		var values []interface{}
+		for _, entry := range entries {
+			values = append(values, entry.Body["i"])
+		}
+		assert.Equal(t, []interface{}{0, 1, 4, 7}, values)
+	})
+
+	t.Run("should sample each level and title separately", func(t *testing.T) {
+		var entries []LogData
+		sampler, _ := newSampler(SamplerConfig{First: 1, Thereafter: -1}, &entries)
+		defer func() { _ = sampler.Close() }()
+
+		for i := 0; i < 3; i++ {
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "title1"})
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "WARN", Title: "title1"})
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "title2"})
+		}
+
+		assert.Equal(t, []LogData{
+			{Level: "INFO", Title: "title1"},
+			{Level: "WARN", Title: "title1"},
+			{Level: "INFO", Title: "title2"},
+		}, entries)
+	})
+
+	t.Run("should not sample errors when they are exempt", func(t *testing.T) {
+		var entries []LogData
+		sampler, _ := newSampler(SamplerConfig{First: 1, Thereafter: -1, ExemptErrors: true}, &entries)
+		defer func() { _ = sampler.Close() }()
+
+		for i := 0; i < 3; i++ {
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "ERROR", Title: "fake-title"})
+		}
+
+		assert.Equal(t, 3, len(entries))
+	})
+
+	t.Run("should write a summary of the suppressed entries when the interval ends", func(t *testing.T) {
+		var entries []LogData
+		sampler, now := newSampler(SamplerConfig{First: 1, Thereafter: -1}, &entries)
+		defer func() { _ = sampler.Close() }()
+
+		start := *now
+		for i := 0; i < 5; i++ {
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "fake-title"})
+		}
+
+		*now = now.Add(time.Hour)
+		_ = sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "fake-title"})
+
+		assert.Equal(t, []LogData{
+			{Level: "INFO", Title: "fake-title"},
+			{
+				Level: "WARN",
+				Title: "log-entries-suppressed",
+				Body: Body{
+					"sampled_level":  "INFO",
+					"sampled_title":  "fake-title",
+					"suppressed":     4,
+					"interval_start": start.Format(time.RFC3339),
+					"interval_end":   now.Format(time.RFC3339),
+				},
+			},
+			{Level: "INFO", Title: "fake-title"},
+		}, entries)
+	})
+
+	t.Run("should write the summary of the current interval on Close", func(t *testing.T) {
+		var entries []LogData
+		sampler, _ := newSampler(SamplerConfig{First: 1, Thereafter: -1}, &entries)
+
+		for i := 0; i < 3; i++ {
+			_ = sampler.WriteLog(time.Now(), &LogData{Level: "INFO", Title: "fake-title"})
+		}
+
+		err := sampler.Close()
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, "log-entries-suppressed", entries[1].Title)
+		assert.Equal(t, 2, entries[1].Body["suppressed"])
+
+		err = sampler.Close()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 2, len(entries))
+	})
+}
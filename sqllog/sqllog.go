@@ -0,0 +1,225 @@
+// Package sqllog wraps database/sql drivers so that every query,
+// exec and transaction is logged with klog using the context
+// received from the caller, which makes the database calls show
+// up along with the other logs of the request:
+//
+//	db, err := sqllog.Open("postgres", dsn, sqllog.Config{
+//		Logger:        logger,
+//		SlowThreshold: 200 * time.Millisecond,
+//	})
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/vingarcia/klog"
+)
+
+// Config contains the configurations for the wrapped drivers.
+type Config struct {
+	// Logger is used for logging the database calls, it is required.
+	Logger klog.Provider
+
+	// Level is the level used for successful calls, defaults to "DEBUG".
+	Level string
+
+	// SlowThreshold raises the level of calls that take
+	// at least this long to "WARN", zero disables it.
+	SlowThreshold time.Duration
+
+	// LogArgs adds the arguments of the statements to the entries,
+	// otherwise only the number of arguments is logged.
+	LogArgs bool
+
+	// RedactArg is called for each argument before it is logged,
+	// defaults to RedactValue.
+	RedactArg func(arg driver.NamedValue) interface{}
+}
+
+// RedactValue replaces strings and byte slices by "[REDACTED]"
+// and keeps the other values, e.g. numbers, booleans and times,
+// since they rarely contain sensitive information.
+func RedactValue(arg driver.NamedValue) interface{} {
+	switch arg.Value.(type) {
+	case string, []byte:
+		return "[REDACTED]"
+	default:
+		return arg.Value
+	}
+}
+
+// Open opens a database using the driver registered with
+// driverName wrapped so that all its calls are logged.
+func Open(driverName string, dsn string, config Config) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+
+	// The DB was only used for looking up the driver:
+	err = db.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if dc, ok := d.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(WrapConnector(connector, config)), nil
+	}
+
+	return sql.OpenDB(dsnConnector{
+		dsn:    dsn,
+		driver: WrapDriver(d, config),
+	}), nil
+}
+
+// WrapDriver returns a driver.Driver that logs all the calls made to d.
+func WrapDriver(d driver.Driver, config Config) driver.Driver {
+	return wrappedDriver{
+		driver: d,
+		logger: newLogger(config),
+	}
+}
+
+// WrapConnector returns a driver.Connector that logs all the
+// calls made to the connections created by c, it should be
+// used with sql.OpenDB.
+func WrapConnector(c driver.Connector, config Config) driver.Connector {
+	return wrappedConnector{
+		connector: c,
+		logger:    newLogger(config),
+	}
+}
+
+type wrappedDriver struct {
+	driver driver.Driver
+	logger *logger
+}
+
+func (d wrappedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{conn: c, logger: d.logger}, nil
+}
+
+func (d wrappedDriver) OpenConnector(name string) (driver.Connector, error) {
+	dc, ok := d.driver.(driver.DriverContext)
+	if !ok {
+		return dsnConnector{dsn: name, driver: d}, nil
+	}
+
+	c, err := dc.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return wrappedConnector{connector: c, logger: d.logger}, nil
+}
+
+type wrappedConnector struct {
+	connector driver.Connector
+	logger    *logger
+}
+
+func (c wrappedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{conn: dc, logger: c.logger}, nil
+}
+
+func (c wrappedConnector) Driver() driver.Driver {
+	return wrappedDriver{driver: c.connector.Driver(), logger: c.logger}
+}
+
+// dsnConnector is used for drivers that don't implement driver.DriverContext.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type logger struct {
+	config Config
+}
+
+func newLogger(config Config) *logger {
+	if config.Logger == nil {
+		panic("sqllog: missing Logger")
+	}
+	if config.Level == "" {
+		config.Level = "DEBUG"
+	}
+	if config.RedactArg == nil {
+		config.RedactArg = RedactValue
+	}
+
+	return &logger{
+		config: config,
+	}
+}
+
+// log logs a single database call, calls that fail with driver.ErrSkip
+// are ignored since database/sql retries them in a different way.
+func (l *logger) log(ctx context.Context, title string, query string, args []driver.NamedValue, start time.Time, err error, body klog.Body) {
+	if err == driver.ErrSkip {
+		return
+	}
+
+	elapsed := time.Since(start)
+	if body == nil {
+		body = klog.Body{}
+	}
+	body["duration_ms"] = float64(elapsed.Microseconds()) / 1000
+
+	if query != "" {
+		body["query"] = query
+		if l.config.LogArgs {
+			values := make([]interface{}, len(args))
+			for i, arg := range args {
+				values[i] = l.config.RedactArg(arg)
+			}
+			body["args"] = values
+		} else {
+			body["args_count"] = len(args)
+		}
+	}
+
+	level := l.config.Level
+	if l.config.SlowThreshold > 0 && elapsed >= l.config.SlowThreshold {
+		level = "WARN"
+		body["slow"] = true
+	}
+	if err != nil {
+		level = "ERROR"
+		body["error"] = err.Error()
+	}
+
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		l.config.Logger.Debug(ctx, title, body)
+	case "WARN":
+		l.config.Logger.Warn(ctx, title, body)
+	case "ERROR":
+		l.config.Logger.Error(ctx, title, body)
+	default:
+		l.config.Logger.Info(ctx, title, body)
+	}
+}
@@ -0,0 +1,247 @@
+package sqllog
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vingarcia/klog"
+)
+
+type logEntry struct {
+	level string
+	title string
+	body  klog.Body
+	ctx   context.Context
+}
+
+func newMockLogger(entries *[]logEntry) klog.Mock {
+	record := func(level string) func(ctx context.Context, title string, body klog.Body) {
+		return func(ctx context.Context, title string, body klog.Body) {
+			*entries = append(*entries, logEntry{
+				level: level,
+				title: title,
+				body:  body,
+				ctx:   ctx,
+			})
+		}
+	}
+
+	return klog.Mock{
+		DebugFn: record("DEBUG"),
+		InfoFn:  record("INFO"),
+		WarnFn:  record("WARN"),
+		ErrorFn: record("ERROR"),
+	}
+}
+
+type ctxKey struct{}
+
+func TestDriver(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "fake-request")
+
+	t.Run("should log execs with the caller context and rows affected", func(t *testing.T) {
+		var entries []logEntry
+		db := sql.OpenDB(WrapConnector(fakeConnector{}, Config{Logger: newMockLogger(&entries)}))
+		defer func() { _ = db.Close() }()
+
+		_, err := db.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", "fake-name", 42)
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "DEBUG", entries[0].level)
+		assert.Equal(t, "sql-exec", entries[0].title)
+		assert.Equal(t, "fake-request", entries[0].ctx.Value(ctxKey{}))
+		assert.Equal(t, "UPDATE users SET name = ? WHERE id = ?", entries[0].body["query"])
+		assert.Equal(t, 2, entries[0].body["args_count"])
+		assert.Equal(t, int64(3), entries[0].body["rows_affected"])
+		_, hasDuration := entries[0].body["duration_ms"]
+		assert.True(t, hasDuration)
+	})
+
+	t.Run("should redact the arguments when they are logged", func(t *testing.T) {
+		var entries []logEntry
+		db := sql.OpenDB(WrapConnector(fakeConnector{}, Config{
+			Logger:  newMockLogger(&entries),
+			LogArgs: true,
+		}))
+		defer func() { _ = db.Close() }()
+
+		rows, err := db.QueryContext(ctx, "SELECT * FROM users WHERE email = ? AND age > ?", "fake@example.com", 18)
+		assert.Equal(t, nil, err)
+		_ = rows.Close()
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "sql-query", entries[0].title)
+		assert.Equal(t, []interface{}{"[REDACTED]", int64(18)}, entries[0].body["args"])
+	})
+
+	t.Run("should log slow calls as WARN and failed calls as ERROR", func(t *testing.T) {
+		var entries []logEntry
+		db := sql.OpenDB(WrapConnector(fakeConnector{}, Config{
+			Logger:        newMockLogger(&entries),
+			SlowThreshold: 10 * time.Millisecond,
+		}))
+		defer func() { _ = db.Close() }()
+
+		_, err := db.ExecContext(ctx, "SLOW")
+		assert.Equal(t, nil, err)
+		_, err = db.ExecContext(ctx, "FAIL")
+		assert.NotEqual(t, nil, err)
+
+		assert.Equal(t, 2, len(entries))
+		assert.Equal(t, "WARN", entries[0].level)
+		assert.Equal(t, true, entries[0].body["slow"])
+		assert.Equal(t, "ERROR", entries[1].level)
+		assert.Equal(t, "fake-error", entries[1].body["error"])
+	})
+
+	t.Run("should log prepared statements of connections without ExecerContext", func(t *testing.T) {
+		var entries []logEntry
+		db := sql.OpenDB(WrapConnector(fakeConnector{prepareOnly: true}, Config{Logger: newMockLogger(&entries)}))
+		defer func() { _ = db.Close() }()
+
+		_, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", 42)
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 1, len(entries))
+		assert.Equal(t, "sql-exec", entries[0].title)
+		assert.Equal(t, "DELETE FROM users WHERE id = ?", entries[0].body["query"])
+		assert.Equal(t, int64(3), entries[0].body["rows_affected"])
+	})
+
+	t.Run("should log transactions with the context used to begin them", func(t *testing.T) {
+		var entries []logEntry
+		db := sql.OpenDB(WrapConnector(fakeConnector{}, Config{Logger: newMockLogger(&entries)}))
+		defer func() { _ = db.Close() }()
+
+		tx, err := db.BeginTx(ctx, nil)
+		assert.Equal(t, nil, err)
+		_, err = tx.Exec("INSERT INTO users VALUES (?)", 42)
+		assert.Equal(t, nil, err)
+		err = tx.Commit()
+		assert.Equal(t, nil, err)
+
+		assert.Equal(t, 3, len(entries))
+		assert.Equal(t, "sql-begin", entries[0].title)
+		assert.Equal(t, "sql-exec", entries[1].title)
+		assert.Equal(t, "sql-commit", entries[2].title)
+		assert.Equal(t, "fake-request", entries[2].ctx.Value(ctxKey{}))
+		_, hasTxDuration := entries[2].body["tx_duration_ms"]
+		assert.True(t, hasTxDuration)
+	})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConnector struct {
+	prepareOnly bool
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	if c.prepareOnly {
+		return fakePrepareConn{}, nil
+	}
+	return fakeConn{}, nil
+}
+
+func (fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// fakePrepareConn only supports prepared statements.
+type fakePrepareConn struct{}
+
+func (fakePrepareConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{query: query}, nil
+}
+
+func (fakePrepareConn) Close() error {
+	return nil
+}
+
+func (fakePrepareConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeConn struct {
+	fakePrepareConn
+}
+
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return fakeExec(query)
+}
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return fakeExec(s.query)
+}
+
+func (fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+func fakeExec(query string) (driver.Result, error) {
+	switch query {
+	case "SLOW":
+		time.Sleep(20 * time.Millisecond)
+	case "FAIL":
+		return nil, errors.New("fake-error")
+	}
+	return driver.RowsAffected(3), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeRows struct {
+	done bool
+}
+
+func (*fakeRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (*fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
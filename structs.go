@@ -0,0 +1,424 @@
+package klog
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/vingarcia/klog/internal/fields"
+	"github.com/vingarcia/klog/internal/visit"
+)
+
+// SetFlattenStructs makes the Client flatten all the structs found on
+// the Body into dotted keys, e.g. a "user" struct with an "id" field
+// becomes the "user.id" key, instead of encoding them as nested objects.
+//
+// Structs that implement json.Marshaler or encoding.TextMarshaler,
+// like time.Time, are not flattened.
+func (c *Client) SetFlattenStructs(flatten bool) {
+	c.convertOptions.flatten = flatten
+}
+
+type convertOptions struct {
+	flatten bool
+	bytes   BytesEncoding
+}
+
+// maxValueDepth limits how deep values are converted,
+// deeper values are left for the JSON encoder.
+const maxValueDepth = 32
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// convertBody resolves the LogValuer values of the Body, at any depth,
+// and replaces the structs that use the `klog` struct tag by maps
+// honoring the tags, or all the structs if flatten is true, in which
+// case they are also flattened into dotted keys.
+//
+// The `klog` tag has the format `klog:"name,omitempty,redact,flatten"`
+// where all parts are optional:
+//
+//   - name: the key used for the field, defaults to the name from the
+//     `json` tag and then to the name of the field itself
+//   - omitempty: omits the field if it has a zero value
+//   - redact: replaces the value of the field by "[REDACTED]"
+//   - flatten: merges the fields of a nested struct into the parent
+//     using dotted keys, e.g. "address.city"
+//
+// Fields tagged with `klog:"-"` are always omitted.
+func convertBody(body Body, opts convertOptions) {
+	c := converter{opts: opts}
+	var flattened Body
+	for k, v := range body {
+		converted, isStruct := c.convertValue(v, 0)
+		if !isStruct || !opts.flatten {
+			body[k] = converted
+			continue
+		}
+
+		if flattened == nil {
+			flattened = Body{}
+		}
+		delete(body, k)
+		for subKey, subValue := range converted.(map[string]interface{}) {
+			flattened[k+"."+subKey] = subValue
+		}
+	}
+
+	for k, v := range flattened {
+		body[k] = v
+	}
+}
+
+type converter struct {
+	opts convertOptions
+
+	// visiting holds the pointers, maps and slices
+	// being converted, for detecting cycles.
+	visiting visit.Set
+}
+
+// convertValue converts the value if needed and reports
+// whether the result was converted from a struct.
+func (c *converter) convertValue(value interface{}, depth int) (interface{}, bool) {
+	if depth >= maxValueDepth {
+		return value, false
+	}
+
+	value = resolveLogValue(value)
+	if value == nil {
+		return nil, false
+	}
+	if depth > 0 {
+		// Errors on the first level are already handled by normalizeLogData:
+		if e, ok := value.(error); ok {
+			return e.Error(), false
+		}
+	}
+
+	rv := reflect.ValueOf(value)
+	switch needsConversion(rv.Type(), c.opts) {
+	case convertNever:
+		return value, false
+	case convertMaybe:
+		if !c.valueNeedsConversion(rv, depth) {
+			return value, false
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if rv.IsNil() {
+			break
+		}
+		if !c.visiting.Enter(rv) {
+			return cycleMarker, false
+		}
+		defer c.visiting.Leave(rv)
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil, false
+		}
+		return c.convertValue(rv.Elem().Interface(), depth+1)
+	case reflect.Struct:
+		return c.structToMap(rv, depth), true
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return value, false
+		}
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			return encodeBytes(rv.Bytes(), c.opts.bytes), false
+		}
+		s := make([]interface{}, rv.Len())
+		for i := range s {
+			s[i], _ = c.convertValue(rv.Index(i).Interface(), depth+1)
+		}
+		return s, false
+	case reflect.Map:
+		if rv.IsNil() {
+			return value, false
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()], _ = c.convertValue(iter.Value().Interface(), depth+1)
+		}
+		return m, false
+	default:
+		return value, false
+	}
+}
+
+func (c *converter) structToMap(rv reflect.Value, depth int) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, f := range cachedStructFields(rv.Type()) {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		if f.redact {
+			m[f.name] = "[REDACTED]"
+			continue
+		}
+
+		if f.inline {
+			if fv.Kind() == reflect.Ptr && fv.IsNil() {
+				continue
+			}
+			if !isMarshaler(fv.Type()) {
+				c.inlineStruct(m, fv, depth+1)
+				continue
+			}
+		}
+
+		value, isStruct := c.convertValue(fv.Interface(), depth+1)
+		if !isStruct {
+			m[f.name] = value
+			continue
+		}
+
+		switch {
+		case f.flatten || c.opts.flatten:
+			for k, v := range value.(map[string]interface{}) {
+				m[f.name+"."+k] = v
+			}
+		default:
+			m[f.name] = value
+		}
+	}
+	return m
+}
+
+// inlineStruct adds the fields of an embedded struct to the map,
+// without overwriting the fields of the outer struct.
+func (c *converter) inlineStruct(m map[string]interface{}, fv reflect.Value, depth int) {
+	if depth >= maxValueDepth {
+		return
+	}
+
+	if fv.Kind() == reflect.Ptr {
+		// An embedded pointer to the outer struct adds no new fields:
+		if !c.visiting.Enter(fv) {
+			return
+		}
+		defer c.visiting.Leave(fv)
+		fv = fv.Elem()
+	}
+
+	for k, v := range c.structToMap(fv, depth) {
+		if _, exists := m[k]; !exists {
+			m[k] = v
+		}
+	}
+}
+
+type structField struct {
+	index     int
+	name      string
+	omitEmpty bool
+	redact    bool
+	flatten   bool
+
+	// inline is used for embedded structs without an explicit
+	// name, whose fields are promoted just like encoding/json does.
+	inline bool
+}
+
+var structFieldsCache sync.Map
+
+func cachedStructFields(t reflect.Type) []structField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]structField)
+	}
+
+	var structFields []structField
+	for _, f := range fields.Of(t, "klog") {
+		structFields = append(structFields, structField{
+			index:     f.Index,
+			name:      f.Name,
+			omitEmpty: f.OmitEmpty,
+			redact:    f.HasOption("redact"),
+			flatten:   f.HasOption("flatten"),
+			inline:    f.Inline,
+		})
+	}
+
+	structFieldsCache.Store(t, structFields)
+	return structFields
+}
+
+type conversionKey struct {
+	t    reflect.Type
+	opts convertOptions
+}
+
+// conversion describes whether the values of a type need
+// to be converted before being encoded.
+type conversion int
+
+const (
+	convertNever conversion = iota
+
+	// convertMaybe is used for the types that hold interfaces, whose
+	// values only need to be converted depending on their contents.
+	convertMaybe
+
+	convertAlways
+)
+
+var needsConversionCache sync.Map
+
+// needsConversion reports whether values of the type
+// need to be converted before being encoded.
+func needsConversion(t reflect.Type, opts convertOptions) conversion {
+	key := conversionKey{t: t, opts: opts}
+	if needs, ok := needsConversionCache.Load(key); ok {
+		return needs.(conversion)
+	}
+
+	needs := computeNeedsConversion(t, opts, map[reflect.Type]bool{})
+	needsConversionCache.Store(key, needs)
+	return needs
+}
+
+// computeNeedsConversion walks the types reachable from t skipping the
+// ones already visited, which stops the recursion on recursive types.
+//
+// Only the result for t is correct, the types found while walking might
+// depend on types still being visited, so they are not cached.
+func computeNeedsConversion(t reflect.Type, opts convertOptions, visited map[reflect.Type]bool) conversion {
+	if needs, ok := needsConversionCache.Load(conversionKey{t: t, opts: opts}); ok {
+		return needs.(conversion)
+	}
+	if visited[t] {
+		return convertNever
+	}
+	visited[t] = true
+
+	// Nested errors are converted to strings:
+	if t.Implements(logValuerType) || (t.Kind() != reflect.Interface && t.Implements(errorType)) {
+		return convertAlways
+	}
+	if isMarshaler(t) {
+		return convertNever
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		// The dynamic type is only known when converting the value:
+		return convertMaybe
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if opts.bytes != Base64Bytes {
+				return convertAlways
+			}
+			return convertNever
+		}
+		return computeNeedsConversion(t.Elem(), opts, visited)
+	case reflect.Ptr, reflect.Array:
+		return computeNeedsConversion(t.Elem(), opts, visited)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return convertNever
+		}
+		return computeNeedsConversion(t.Elem(), opts, visited)
+	case reflect.Struct:
+		if opts.flatten {
+			return convertAlways
+		}
+		needs := convertNever
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if _, ok := sf.Tag.Lookup("klog"); ok {
+				return convertAlways
+			}
+			if sf.PkgPath != "" {
+				// Unexported fields are never converted:
+				continue
+			}
+			if fieldNeeds := computeNeedsConversion(sf.Type, opts, visited); fieldNeeds > needs {
+				needs = fieldNeeds
+			}
+		}
+		return needs
+	default:
+		return convertNever
+	}
+}
+
+// valueNeedsConversion checks the contents of the values whose types
+// hold interfaces, so that they are only copied when one of the values
+// they hold needs to be converted.
+func (c *converter) valueNeedsConversion(rv reflect.Value, depth int) bool {
+	if depth >= maxValueDepth {
+		return false
+	}
+
+	switch needsConversion(rv.Type(), c.opts) {
+	case convertNever:
+		return false
+	case convertAlways:
+		return true
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if rv.IsNil() {
+			return false
+		}
+		// Cycles are left for the encoder to handle:
+		if !c.visiting.Enter(rv) {
+			return false
+		}
+		defer c.visiting.Leave(rv)
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return false
+		}
+		return c.valueNeedsConversion(rv.Elem(), depth+1)
+	case reflect.Ptr:
+		return c.valueNeedsConversion(rv.Elem(), depth+1)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if c.valueNeedsConversion(rv.Index(i), depth+1) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			if c.valueNeedsConversion(iter.Value(), depth+1) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for _, f := range cachedStructFields(rv.Type()) {
+			if c.valueNeedsConversion(rv.Field(f.index), depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isMarshaler reports whether the type controls its own JSON encoding.
+func isMarshaler(t reflect.Type) bool {
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	if t.Kind() == reflect.Ptr {
+		return false
+	}
+	ptr := reflect.PtrTo(t)
+	return ptr.Implements(jsonMarshalerType) || ptr.Implements(textMarshalerType)
+}
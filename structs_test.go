@@ -0,0 +1,205 @@
+package klog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOwner struct {
+	Pet *fakePet
+}
+
+type fakePet struct {
+	Owner  *fakeOwner
+	Secret string `klog:"secret,redact"`
+}
+
+func TestStructTags(t *testing.T) {
+	type address struct {
+		City    string `klog:"city"`
+		ZipCode string `klog:"zip,omitempty"`
+	}
+
+	type Audit struct {
+		CreatedBy string `json:"created_by"`
+	}
+
+	type user struct {
+		Audit
+
+		ID       int       `klog:"id"`
+		Name     string    `json:"name"`
+		Password string    `klog:"password,redact"`
+		Token    string    `klog:"-"`
+		Nickname string    `klog:",omitempty"`
+		Address  address   `klog:"address,flatten"`
+		Billing  *address  `klog:"billing"`
+		Created  time.Time `klog:"created"`
+		internal string
+	}
+
+	created := parseTime(t, "2024-10-09T09:00:00Z")
+	fakeUser := user{
+		Audit:    Audit{CreatedBy: "admin"},
+		ID:       42,
+		Name:     "fake-name",
+		Password: "fake-password",
+		Token:    "fake-token",
+		Address:  address{City: "fake-city"},
+		Billing:  &address{City: "billing-city", ZipCode: "12345"},
+		Created:  created,
+		internal: "internal",
+	}
+
+	tests := []struct {
+		desc           string
+		flatten        bool
+		body           Body
+		expectedOutput string
+	}{
+		{
+			desc: "should honor the klog struct tags",
+			body: Body{"user": fakeUser},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title","user":{` +
+				`"address.city":"fake-city",` +
+				`"billing":{"city":"billing-city","zip":"12345"},` +
+				`"created":"2024-10-09T09:00:00Z",` +
+				`"created_by":"admin",` +
+				`"id":42,` +
+				`"name":"fake-name",` +
+				`"password":"[REDACTED]"` +
+				`}}`,
+		},
+		{
+			desc:    "should flatten all structs into dotted keys when enabled",
+			flatten: true,
+			body:    Body{"user": &fakeUser, "other": "value"},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"other":"value",` +
+				`"user.address.city":"fake-city",` +
+				`"user.billing.city":"billing-city",` +
+				`"user.billing.zip":"12345",` +
+				`"user.created":"2024-10-09T09:00:00Z",` +
+				`"user.created_by":"admin",` +
+				`"user.id":42,` +
+				`"user.name":"fake-name",` +
+				`"user.password":"[REDACTED]"` +
+				`}`,
+		},
+		{
+			desc: "should not change structs without klog tags",
+			body: Body{"audit": Audit{CreatedBy: "admin"}},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"audit":{"created_by":"admin"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var output string
+			client := New("INFO")
+			client.timeNow = func() time.Time {
+				return created
+			}
+			client.OutputHandler = func(data *LogData) {
+				output = buildJSONString(created, data)
+			}
+			client.SetFlattenStructs(test.flatten)
+
+			client.Info(context.TODO(), "fake-title", test.body)
+
+			assert.Equal(t, test.expectedOutput, output)
+		})
+	}
+
+	t.Run("should not modify the values passed to the logger", func(t *testing.T) {
+		u := fakeUser
+		client := New("INFO")
+		client.OutputHandler = func(*LogData) {}
+
+		client.Info(context.TODO(), "fake-title", Body{"user": &u})
+
+		assert.Equal(t, fakeUser, u)
+	})
+	t.Run("should only copy the maps and slices of interfaces that need conversion", func(t *testing.T) {
+		plain := map[string]interface{}{
+			"list": []interface{}{1, "two", map[string]interface{}{"three": 3.0}},
+		}
+		withValuer := map[string]interface{}{
+			"list": []interface{}{1, fakeFunc(func() interface{} {
+				return "resolved"
+			})},
+		}
+
+		var data *LogData
+		client := New("INFO")
+		client.OutputHandler = func(d *LogData) {
+			data = d
+		}
+
+		client.Info(context.TODO(), "fake-title", Body{"plain": plain, "withValuer": withValuer})
+
+		assert.Equal(t, reflect.ValueOf(plain).Pointer(), reflect.ValueOf(data.Body["plain"]).Pointer())
+		assert.Equal(t, map[string]interface{}{
+			"list": []interface{}{1, "resolved"},
+		}, data.Body["withValuer"])
+	})
+
+	t.Run("should stop on embedded pointers to the struct itself", func(t *testing.T) {
+		type Cyc struct {
+			*Cyc
+			Name string `klog:"name"`
+		}
+		c := &Cyc{Name: "fake-name"}
+		c.Cyc = c
+
+		var data *LogData
+		client := New("INFO")
+		client.OutputHandler = func(d *LogData) {
+			data = d
+		}
+
+		client.Info(context.TODO(), "fake-title", Body{"cyc": c})
+
+		assert.Equal(t, map[string]interface{}{"name": "fake-name"}, data.Body["cyc"])
+	})
+
+	t.Run("should redact the fields of recursive types", func(t *testing.T) {
+		type node struct {
+			Next     *node
+			Password string `klog:"password,redact"`
+		}
+
+		var data *LogData
+		client := New("INFO")
+		client.OutputHandler = func(d *LogData) {
+			data = d
+		}
+
+		client.Info(context.TODO(), "fake-title", Body{"node": node{
+			Next:     &node{Password: "fake-password"},
+			Password: "fake-password",
+		}})
+		assert.Equal(t, map[string]interface{}{
+			"Next": map[string]interface{}{
+				"Next":     nil,
+				"password": "[REDACTED]",
+			},
+			"password": "[REDACTED]",
+		}, data.Body["node"])
+
+		// The types reached while checking fakePet must not be cached as not
+		// needing conversion, since fakeOwner reaches fakePet as well:
+		client.Info(context.TODO(), "fake-title", Body{"pet": fakePet{}})
+		client.Info(context.TODO(), "fake-title", Body{"owner": fakeOwner{Pet: &fakePet{Secret: "fake-secret"}}})
+		assert.Equal(t, map[string]interface{}{
+			"Pet": map[string]interface{}{
+				"Owner":  nil,
+				"secret": "[REDACTED]",
+			},
+		}, data.Body["owner"])
+	})
+}
@@ -0,0 +1,121 @@
+package klog
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+)
+
+// TraceSamplerConfig contains the configurations for the TraceSampler.
+type TraceSamplerConfig struct {
+	// Ratio is the fraction of the traces, from 0 to 1, that
+	// have their "DEBUG" entries logged.
+	Ratio float64
+
+	// IDFunc reads the trace or request ID from the context,
+	// e.g. httplog.RequestID or otelklog.TraceID, it is required.
+	//
+	// "DEBUG" entries logged with a context without an ID are dropped,
+	// unless sampling is forced with ContextWithSampling.
+	IDFunc func(ctx context.Context) string
+}
+
+// TraceSampler is a Provider that samples the "DEBUG" entries per trace
+// instead of per entry, all other levels are always logged.
+//
+// The decision is derived only from the trace ID, so all services
+// using the same Ratio log either all the "DEBUG" entries of a trace
+// or none of them. A trace is sampled when the FNV-1a 64 bit hash of
+// its ID is below Ratio * 2^64.
+type TraceSampler struct {
+	provider  Provider
+	idFunc    func(ctx context.Context) string
+	threshold uint64
+	all       bool
+}
+
+var _ Provider = TraceSampler{}
+
+// NewTraceSampler returns a TraceSampler that logs the sampled
+// entries using the received Provider:
+//
+//	logger := klog.NewTraceSampler(klog.New("DEBUG"), klog.TraceSamplerConfig{
+//		Ratio:  0.01,
+//		IDFunc: httplog.RequestID,
+//	})
+func NewTraceSampler(provider Provider, config TraceSamplerConfig) TraceSampler {
+	if config.IDFunc == nil {
+		panic("klog: missing IDFunc")
+	}
+
+	s := TraceSampler{
+		provider: provider,
+		idFunc:   config.IDFunc,
+	}
+	switch {
+	case config.Ratio >= 1:
+		s.all = true
+	case config.Ratio > 0:
+		s.threshold = uint64(config.Ratio * math.MaxUint64)
+	}
+
+	return s
+}
+
+type samplingCtxKey struct{}
+
+// ContextWithSampling returns a context that overrides the decision
+// of the TraceSampler, forcing the "DEBUG" entries logged with it
+// to be either kept or dropped.
+func ContextWithSampling(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, samplingCtxKey{}, sampled)
+}
+
+// Sampled reports whether the "DEBUG" entries logged
+// with the received context are kept.
+func (s TraceSampler) Sampled(ctx context.Context) bool {
+	if sampled, ok := ctx.Value(samplingCtxKey{}).(bool); ok {
+		return sampled
+	}
+
+	id := s.idFunc(ctx)
+	if id == "" {
+		return false
+	}
+	if s.all {
+		return true
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64() < s.threshold
+}
+
+// Debug logs the entry only if the trace of the context is sampled.
+func (s TraceSampler) Debug(ctx context.Context, title string, valueMaps ...Body) {
+	if !s.Sampled(ctx) {
+		return
+	}
+
+	s.provider.Debug(ctx, title, valueMaps...)
+}
+
+// Info implements the Provider interface
+func (s TraceSampler) Info(ctx context.Context, title string, valueMaps ...Body) {
+	s.provider.Info(ctx, title, valueMaps...)
+}
+
+// Warn implements the Provider interface
+func (s TraceSampler) Warn(ctx context.Context, title string, valueMaps ...Body) {
+	s.provider.Warn(ctx, title, valueMaps...)
+}
+
+// Error implements the Provider interface
+func (s TraceSampler) Error(ctx context.Context, title string, valueMaps ...Body) {
+	s.provider.Error(ctx, title, valueMaps...)
+}
+
+// Fatal implements the Provider interface
+func (s TraceSampler) Fatal(ctx context.Context, title string, valueMaps ...Body) {
+	s.provider.Fatal(ctx, title, valueMaps...)
+}
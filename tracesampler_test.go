@@ -0,0 +1,106 @@
+package klog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceIDCtxKey struct{}
+
+func TestTraceSampler(t *testing.T) {
+	traceID := func(ctx context.Context) string {
+		id, _ := ctx.Value(traceIDCtxKey{}).(string)
+		return id
+	}
+
+	newSampler := func(ratio float64, titles *[]string) TraceSampler {
+		record := func(ctx context.Context, title string, body Body) {
+			*titles = append(*titles, title)
+		}
+		return NewTraceSampler(Mock{
+			DebugFn: record,
+			InfoFn:  record,
+			WarnFn:  record,
+			ErrorFn: record,
+		}, TraceSamplerConfig{
+			Ratio:  ratio,
+			IDFunc: traceID,
+		})
+	}
+
+	t.Run("should log all or none of the debug entries of a trace", func(t *testing.T) {
+		var titles []string
+		sampler := newSampler(0.5, &titles)
+
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			ctx := context.WithValue(context.Background(), traceIDCtxKey{}, fmt.Sprint("trace-", i))
+
+			titles = nil
+			sampler.Debug(ctx, "first")
+			sampler.Debug(ctx, "second")
+			if len(titles) == 2 {
+				sampled++
+			} else {
+				assert.Equal(t, 0, len(titles))
+			}
+
+			// The decision must be the same every time:
+			assert.Equal(t, len(titles) == 2, sampler.Sampled(ctx))
+		}
+
+		assert.True(t, sampled > 400 && sampled < 600, "sampled %d of 1000 traces", sampled)
+	})
+
+	t.Run("should always log the other levels", func(t *testing.T) {
+		var titles []string
+		sampler := newSampler(0, &titles)
+
+		ctx := context.WithValue(context.Background(), traceIDCtxKey{}, "fake-trace")
+		sampler.Debug(ctx, "debug")
+		sampler.Info(ctx, "info")
+		sampler.Warn(ctx, "warn")
+		sampler.Error(ctx, "error")
+
+		assert.Equal(t, []string{"info", "warn", "error"}, titles)
+	})
+
+	tests := []struct {
+		desc           string
+		ratio          float64
+		ctx            context.Context
+		expectedTitles []string
+	}{
+		{
+			desc:           "should drop debug entries without a trace ID",
+			ratio:          1,
+			ctx:            context.Background(),
+			expectedTitles: nil,
+		},
+		{
+			desc:           "should log debug entries when sampling is forced",
+			ratio:          0,
+			ctx:            ContextWithSampling(context.Background(), true),
+			expectedTitles: []string{"debug"},
+		},
+		{
+			desc:           "should drop debug entries when sampling is disabled on the context",
+			ratio:          1,
+			ctx:            ContextWithSampling(context.WithValue(context.Background(), traceIDCtxKey{}, "fake-trace"), false),
+			expectedTitles: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var titles []string
+			sampler := newSampler(test.ratio, &titles)
+
+			sampler.Debug(test.ctx, "debug")
+
+			assert.Equal(t, test.expectedTitles, titles)
+		})
+	}
+}
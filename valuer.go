@@ -0,0 +1,54 @@
+package klog
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// LogValuer is implemented by types that control their own
+// representation on the logs, e.g. for hiding sensitive fields
+// or for logging an expensive summary of the value.
+//
+// LogValue is not called for entries discarded by the level of the
+// Client, it is only called right before the middlewares run, and the
+// value it returns is also resolved, so it may contain other LogValuers.
+type LogValuer interface {
+	LogValue() interface{}
+}
+
+// maxLogValueCalls limits how many times LogValue is called in
+// a row for a single value, since each result can be a LogValuer.
+const maxLogValueCalls = 16
+
+var logValuerType = reflect.TypeOf((*LogValuer)(nil)).Elem()
+
+func resolveLogValue(value interface{}) interface{} {
+	for i := 0; i < maxLogValueCalls; i++ {
+		valuer, ok := value.(LogValuer)
+		if !ok {
+			return value
+		}
+		value = callLogValue(valuer)
+	}
+
+	if _, ok := value.(LogValuer); ok {
+		return fmt.Sprintf("!LogValue: exceeded %d nested LogValue calls", maxLogValueCalls)
+	}
+	return value
+}
+
+func callLogValue(valuer LogValuer) (value interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			value = fmt.Sprintf("!LogValue panic: %v", r)
+		}
+	}()
+
+	rv := reflect.ValueOf(valuer)
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		// Calling LogValue on a nil pointer would most likely panic:
+		return nil
+	}
+
+	return valuer.LogValue()
+}
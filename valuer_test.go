@@ -0,0 +1,116 @@
+package klog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeToken string
+
+func (t fakeToken) LogValue() interface{} {
+	return "token-" + string(t)[:4] + "..."
+}
+
+type fakeAccount struct {
+	ID     int
+	Tokens []fakeToken
+}
+
+func (a *fakeAccount) LogValue() interface{} {
+	return Body{
+		"id":     a.ID,
+		"tokens": a.Tokens,
+	}
+}
+
+type fakeLoop struct{}
+
+func (l fakeLoop) LogValue() interface{} {
+	return l
+}
+
+type fakePanic struct{}
+
+func (fakePanic) LogValue() interface{} {
+	panic("fake-panic")
+}
+
+func TestLogValuer(t *testing.T) {
+	tests := []struct {
+		desc           string
+		body           Body
+		expectedOutput string
+	}{
+		{
+			desc: "should use the value returned by LogValue",
+			body: Body{"token": fakeToken("abcdefgh")},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"token":"token-abcd..."}`,
+		},
+		{
+			desc: "should resolve LogValuers recursively",
+			body: Body{
+				"account": &fakeAccount{ID: 42, Tokens: []fakeToken{"12345678"}},
+				"nested":  map[string]interface{}{"token": fakeToken("abcdefgh")},
+			},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"account":{"id":42,"tokens":["token-1234..."]},` +
+				`"nested":{"token":"token-abcd..."}}`,
+		},
+		{
+			desc: "should limit the number of nested LogValue calls",
+			body: Body{"loop": fakeLoop{}},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"loop":"!LogValue: exceeded 16 nested LogValue calls"}`,
+		},
+		{
+			desc: "should recover from panics",
+			body: Body{"panic": fakePanic{}},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"panic":"!LogValue panic: fake-panic"}`,
+		},
+		{
+			desc: "should log nil pointers as null",
+			body: Body{"account": (*fakeAccount)(nil)},
+			expectedOutput: `{"timestamp":"2024-10-09T09:00:00Z","level":"INFO","title":"fake-title",` +
+				`"account":null}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			now := parseTime(t, "2024-10-09T09:00:00Z")
+
+			var output string
+			client := New("INFO")
+			client.OutputHandler = func(data *LogData) {
+				output = buildJSONString(now, data)
+			}
+
+			client.Info(context.TODO(), "fake-title", test.body)
+
+			assert.Equal(t, test.expectedOutput, output)
+		})
+	}
+
+	t.Run("should not call LogValue for entries below the level", func(t *testing.T) {
+		called := false
+		client := New("INFO")
+		client.OutputHandler = func(*LogData) {}
+
+		client.Debug(context.TODO(), "fake-title", Body{"value": fakeFunc(func() interface{} {
+			called = true
+			return nil
+		})})
+
+		assert.Equal(t, false, called)
+	})
+
+}
+
+type fakeFunc func() interface{}
+
+func (f fakeFunc) LogValue() interface{} {
+	return f()
+}